@@ -5,6 +5,7 @@ go 1.25.7
 require (
 	github.com/bradleyfalzon/ghinstallation/v2 v2.19.0
 	github.com/cli/go-gh/v2 v2.12.2
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/google/go-github/v33 v33.0.0
 	github.com/k1LoW/httpstub v0.28.3
 	github.com/migueleliasweb/go-github-mock v1.5.0
@@ -18,7 +19,6 @@ require (
 	github.com/cli/safeexec v1.0.1 // indirect
 	github.com/go-openapi/jsonpointer v0.23.1 // indirect
 	github.com/go-openapi/swag/jsonname v0.26.0 // indirect
-	github.com/golang-jwt/jwt/v4 v4.5.2 // indirect
 	github.com/google/go-github/v73 v73.0.0 // indirect
 	github.com/google/go-github/v88 v88.0.0 // indirect
 	github.com/google/go-querystring v1.2.0 // indirect
@@ -0,0 +1,72 @@
+package factory
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/go-github/v34/github"
+)
+
+type contextKey int
+
+const (
+	tokenContextKey contextKey = iota
+	installationIDContextKey
+)
+
+// ContextCredentials enables to authenticate each request using the credentials of its context ( WithToken or WithInstallationID ),
+// so that one client ( and its connection pool ) can be shared by many users.
+// Requests without credentials in the context are sent without authentication.
+// Authentication using installation IDs requires GitHub App credentials.
+// GetCredentialInfo and TokenExpirationWarning do not track the credentials of contexts.
+// go-github keeps the rate limits per client, so a user exceeding the rate limit blocks requests of the other users of the client
+// until the reset. Use NewTenantClient to build a client per user sharing the transport.
+func ContextCredentials() Option {
+	return func(c *Config) error {
+		c.ContextCredentials = true
+		return nil
+	}
+}
+
+// NewTenantClient returns a new github.com/google/go-github/v34/github.Client sharing the transport ( and its connection pool )
+// of c built with ContextCredentials. The rate limits of the client are kept apart from the ones of c and the other clients.
+func NewTenantClient(c *github.Client) (*github.Client, error) {
+	s, ok := lookupClientState(c)
+	if !ok || s.contextClient == nil {
+		return nil, errors.New("the client is not built with ContextCredentials")
+	}
+	v3c := github.NewClient(s.contextClient)
+	baseURL, uploadURL := *c.BaseURL, *c.UploadURL
+	v3c.BaseURL, v3c.UploadURL = &baseURL, &uploadURL
+	registerClientState(v3c, s)
+	return v3c, nil
+}
+
+// WithToken returns a copy of ctx with the token used by the client built with ContextCredentials.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenContextKey, token)
+}
+
+// WithInstallationID returns a copy of ctx with the GitHub App installation ID used by the client built with ContextCredentials.
+func WithInstallationID(ctx context.Context, installationID int64) context.Context {
+	return context.WithValue(ctx, installationIDContextKey, installationID)
+}
+
+// tokenFromContext returns the token of the context. The installation token is used if the context has the installation ID.
+func (rt *roundTripper) tokenFromContext(ctx context.Context) (string, error) {
+	if token, ok := ctx.Value(tokenContextKey).(string); ok && token != "" {
+		return token, nil
+	}
+	installationID, ok := ctx.Value(installationIDContextKey).(int64)
+	if !ok || installationID == 0 {
+		return "", nil
+	}
+	if rt.pool == nil {
+		return "", errNoAppCredentials
+	}
+	itr, err := rt.pool.transport(installationID)
+	if err != nil {
+		return "", err
+	}
+	return itr.Token(ctx)
+}
@@ -0,0 +1,379 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cli/go-gh/v2/pkg/auth"
+	"github.com/google/go-github/v34/github"
+)
+
+// AuthMode is the mode of authentication.
+type AuthMode string
+
+const (
+	// AuthModeToken authenticates using a token.
+	AuthModeToken AuthMode = "token"
+	// AuthModeApp authenticates as an installation of a GitHub App.
+	AuthModeApp AuthMode = "app"
+	// AuthModeAnonymous does not authenticate.
+	AuthModeAnonymous AuthMode = "anonymous"
+	// AuthModeContext authenticates each request using the credentials of its context.
+	AuthModeContext AuthMode = "context"
+)
+
+// Detected is the result of detection of the host, endpoints and credentials. The endpoints are URLs without a trailing slash.
+// Token is a secret. Detected redacts it when formatted using fmt or logged using log/slog.
+type Detected struct {
+	Host            string
+	HostSource      string
+	RESTEndpoint    string
+	UploadEndpoint  string
+	GraphQLEndpoint string
+	Token           string
+	TokenSource     string
+	Owner           string
+	Repo            string
+	AuthMode        AuthMode
+	// AnonymousFallback is true when no credentials are found and the client falls back to unauthenticated access ( AllowAnonymous ).
+	// Unauthenticated access is limited to 60 requests per hour.
+	AnonymousFallback bool
+
+	// tried is the sources of tokens tried without a token.
+	tried []string
+}
+
+const redactedToken = "REDACTED"
+
+// detectedView is Detected to be printed and logged. The token is redacted.
+type detectedView struct {
+	Host              string
+	HostSource        string
+	RESTEndpoint      string
+	UploadEndpoint    string
+	GraphQLEndpoint   string
+	Token             string
+	TokenSource       string
+	Owner             string
+	Repo              string
+	AuthMode          AuthMode
+	AnonymousFallback bool
+}
+
+func (d Detected) view() detectedView {
+	token := ""
+	if d.Token != "" {
+		token = redactedToken
+	}
+	return detectedView{
+		Host:              d.Host,
+		HostSource:        d.HostSource,
+		RESTEndpoint:      d.RESTEndpoint,
+		UploadEndpoint:    d.UploadEndpoint,
+		GraphQLEndpoint:   d.GraphQLEndpoint,
+		Token:             token,
+		TokenSource:       d.TokenSource,
+		Owner:             d.Owner,
+		Repo:              d.Repo,
+		AuthMode:          d.AuthMode,
+		AnonymousFallback: d.AnonymousFallback,
+	}
+}
+
+// String returns the detection result with the token redacted. Token is a secret, so print and log Detected instead of Token.
+func (d Detected) String() string {
+	return fmt.Sprintf("%+v", d.view())
+}
+
+// GoString returns the detection result with the token redacted for the %#v verb.
+func (d Detected) GoString() string {
+	return "factory.Detected" + strings.TrimPrefix(fmt.Sprintf("%#v", d.view()), "factory.detectedView")
+}
+
+// LogValue returns the detection result with the token redacted for log/slog.
+func (d Detected) LogValue() slog.Value {
+	v := d.view()
+	return slog.GroupValue(
+		slog.String("Host", v.Host),
+		slog.String("HostSource", v.HostSource),
+		slog.String("RESTEndpoint", v.RESTEndpoint),
+		slog.String("UploadEndpoint", v.UploadEndpoint),
+		slog.String("GraphQLEndpoint", v.GraphQLEndpoint),
+		slog.String("Token", v.Token),
+		slog.String("TokenSource", v.TokenSource),
+		slog.String("Owner", v.Owner),
+		slog.String("Repo", v.Repo),
+		slog.String("AuthMode", string(v.AuthMode)),
+		slog.Bool("AnonymousFallback", v.AnonymousFallback),
+	)
+}
+
+// AllowAnonymous enables to fall back to unauthenticated access when no credentials are found.
+// Detected.AnonymousFallback reports the fallback. Invalid GitHub App credentials are still returned as *AppAuthError.
+func AllowAnonymous() Option {
+	return func(c *Config) error {
+		c.AllowAnonymous = true
+		return nil
+	}
+}
+
+// Detect returns the host, endpoints and credentials detected in the same way as NewGithubClient.
+func Detect(opts ...Option) (*Detected, error) {
+	c, err := newConfigWithOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return detect(context.Background(), c)
+}
+
+// NewGithubClientFromDetected returns github.com/google/go-github/v34/github.Client built from the detection result.
+// The host, endpoints and token are taken from d, and opts configure the rest ( timeouts, GitHub App credentials, etc. ).
+func NewGithubClientFromDetected(d *Detected, opts ...Option) (*github.Client, error) {
+	if d == nil {
+		return nil, errors.New("detection result is nil")
+	}
+	c, err := newConfigWithOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newGithubClientFromDetected(c, d)
+}
+
+func newGithubClientFromDetected(c *Config, d *Detected) (*github.Client, error) {
+	switch d.AuthMode {
+	case AuthModeToken:
+		c.Token = d.Token
+	case AuthModeApp:
+		c.Token = ""
+		if c.HTTPClient == nil {
+			if c.Owner == "" {
+				c.Owner = d.Owner
+				c.Repo = d.Repo
+			}
+			hc, err := newHTTPClientUsingGitHubApp(c, d.RESTEndpoint)
+			if err != nil {
+				var ae *AppAuthError
+				if errors.As(err, &ae) {
+					ae.Tried = d.tried
+				}
+				return nil, err
+			}
+			c.HTTPClient = hc
+		}
+	default:
+		c.Token = ""
+	}
+	var pool *InstallationPool
+	if d.AuthMode == AuthModeContext && c.HTTPClient == nil {
+		if _, _, _, err := appCredentials(c); err == nil {
+			p, err := newInstallationPool(c, d)
+			if err != nil {
+				return nil, err
+			}
+			pool = p
+		}
+	}
+
+	s := newClientState(c)
+	s.info.TokenType = detectTokenType(c.Token)
+	if d.AuthMode == AuthModeApp {
+		s.info.TokenType = TokenTypeInstallation
+	}
+	hc := httpClient(c)
+	if rt, ok := hc.Transport.(*roundTripper); ok {
+		rt.hosts = authorizedHosts(c, d.RESTEndpoint, d.UploadEndpoint, d.GraphQLEndpoint)
+		// The responses for the credentials of contexts belong to each user, not to the client
+		if d.AuthMode != AuthModeContext {
+			rt.state = s
+		}
+		if d.AuthMode == AuthModeToken && d.TokenSource == userTokenSource && c.UserAccessToken != nil {
+			rt.refresher = newUserTokenRefresher(c, d.RESTEndpoint)
+		}
+		if d.AuthMode == AuthModeContext {
+			rt.fromContext = true
+			rt.pool = pool
+		}
+		if c.Failover && d.AuthMode == AuthModeToken {
+			hc.Transport = newFailoverTransport(c, d, rt)
+		}
+	}
+	if d.AuthMode == AuthModeContext {
+		s.contextClient = hc
+	}
+	v3c := github.NewClient(hc)
+	if err := setEndpoints(v3c, d.RESTEndpoint, d.UploadEndpoint); err != nil {
+		return nil, err
+	}
+	registerClientState(v3c, s)
+	if c.Preflight {
+		if err := preflight(context.Background(), v3c, hc, s); err != nil {
+			return nil, err
+		}
+	}
+
+	return v3c, nil
+}
+
+func detect(ctx context.Context, c *Config) (*Detected, error) {
+	d, err := detectHostAndEndpoints(c)
+	if err != nil {
+		return nil, err
+	}
+	if owner, repo, err := detectOwnerRepo(c); err == nil {
+		d.Owner = owner
+		d.Repo = repo
+	}
+	if c.ContextCredentials {
+		d.AuthMode = AuthModeContext
+		return d, nil
+	}
+	var tried []string
+	d.Token, d.TokenSource, tried, err = resolveCredentials(ctx, c, d.Host)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case d.Token != "":
+		d.AuthMode = AuthModeToken
+	case c.SkipAuth || c.HTTPClient != nil:
+		d.AuthMode = AuthModeAnonymous
+	default:
+		appID, _, _, appErr := appCredentials(c)
+		if appErr == nil {
+			d.AuthMode = AuthModeApp
+			d.tried = tried
+			break
+		}
+		if !errors.Is(appErr, errNoAppCredentials) {
+			// GitHub App credentials are set but invalid
+			return nil, &AppAuthError{AppID: appID, Tried: tried, Err: appErr}
+		}
+		if c.DeviceFlowClientID == "" {
+			if c.AllowAnonymous {
+				d.AuthMode = AuthModeAnonymous
+				d.AnonymousFallback = true
+				break
+			}
+			return nil, noCredentialsError(append(tried, "GitHub App"))
+		}
+		// No GitHub App credentials, so login using device flow
+		d.Token, err = deviceFlowToken(ctx, c, d.RESTEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		d.TokenSource = "factory.DeviceFlow"
+		d.AuthMode = AuthModeToken
+	}
+	return d, nil
+}
+
+// detectHostAndEndpoints detects the host and endpoints. Config.Host and Config.Endpoint take precedence over the detected ones.
+func detectHostAndEndpoints(c *Config) (*Detected, error) {
+	var host, hostSource, v3ep, v3upload, v4ep string
+	if c.Host != "" {
+		host, hostSource = c.Host, "factory.Host"
+		// Environment variables for the default host are not used for the host set explicitly
+		v3ep, v3upload, v4ep = endpointsForHost(host)
+	} else {
+		host, hostSource = detectHost(c)
+		v3ep, v3upload, v4ep = detectEndpoints(host)
+	}
+	if c.Endpoint != "" {
+		ep, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		v3ep = strings.TrimSuffix(c.Endpoint, "/")
+		v3upload, v4ep = endpointsForAPIEndpoint(ep)
+	}
+	if c.UploadEndpoint != "" {
+		v3upload = strings.TrimSuffix(c.UploadEndpoint, "/")
+	}
+	if c.GraphQLEndpoint != "" {
+		v4ep = strings.TrimSuffix(c.GraphQLEndpoint, "/")
+	}
+	return &Detected{
+		Host:            host,
+		HostSource:      hostSource,
+		RESTEndpoint:    v3ep,
+		UploadEndpoint:  v3upload,
+		GraphQLEndpoint: v4ep,
+	}, nil
+}
+
+// detectHost detects the host in the order of the host of env GH_REPO ( HOST/OWNER/REPO ), env GH_HOST,
+// the git remote ( GitRemote ) and the gh config.
+func detectHost(c *Config) (host, source string) {
+	// GH_REPO is used only when the owner is not set ( see detectOwnerRepo )
+	ghRepo := ""
+	if c.Owner == "" {
+		ghRepo = os.Getenv("GH_REPO")
+	}
+	if splitted := strings.Split(ghRepo, "/"); len(splitted) == 3 && splitted[0] != "" {
+		return strings.ToLower(splitted[0]), "GH_REPO"
+	}
+	host, source = auth.DefaultHost()
+	// The git remote is not used for the repository set by GH_REPO
+	if c.GitRemote != "" && ghRepo == "" && os.Getenv("GH_HOST") == "" {
+		// The host of the remote may be an alias ( SSH config or url.<base>.insteadOf ), so unknown hosts are not used
+		if r, err := detectGitRemote(c.GitRemote); err == nil && isKnownHost(r.host) {
+			return r.host, fmt.Sprintf("git remote %s", c.GitRemote)
+		}
+	}
+	return host, source
+}
+
+// detectEndpoints returns endpoints for the host. The endpoints to be generated are URLs without a trailing slash.
+func detectEndpoints(host string) (v3ep, v3upload, v4ep string) {
+	v3ep, v3upload, v4ep = endpointsForHost(host)
+	if host == defaultHost {
+		// GitHub Actions or GitHub.com
+		if os.Getenv("GITHUB_API_URL") != "" {
+			v3ep = os.Getenv("GITHUB_API_URL")
+			ep, err := url.Parse(v3ep)
+			if err == nil && ep.Host != "" {
+				v3upload, v4ep = endpointsForAPIEndpoint(ep)
+			}
+		}
+		if os.Getenv("GITHUB_GRAPHQL_URL") != "" {
+			v4ep = os.Getenv("GITHUB_GRAPHQL_URL")
+		}
+	}
+	return v3ep, v3upload, v4ep
+}
+
+// endpointsForHost returns endpoints for the host without environment variables. The endpoints to be generated are URLs without a trailing slash.
+func endpointsForHost(host string) (v3ep, v3upload, v4ep string) {
+	if host == defaultHost {
+		return defaultV3Endpoint, defaultUploadEndpoint, defaultV4Endpoint
+	}
+	if isTenancyHost(host) {
+		// GitHub Enterprise Cloud with data residency
+		return fmt.Sprintf("https://api.%s", host), fmt.Sprintf("https://uploads.%s", host), fmt.Sprintf("https://api.%s/graphql", host)
+	}
+	// GitHub Enterprise Server
+	return fmt.Sprintf("https://%s/api/v3", host), fmt.Sprintf("https://%s/api/uploads", host), fmt.Sprintf("https://%s/api/graphql", host)
+}
+
+// endpointsForAPIEndpoint returns the upload and GraphQL endpoints derived from the REST API endpoint.
+// The scheme and port of the REST API endpoint are preserved.
+func endpointsForAPIEndpoint(ep *url.URL) (v3upload, v4ep string) {
+	switch {
+	case strings.Contains(ep.Host, defaultHost):
+		return defaultUploadEndpoint, defaultV4Endpoint
+	case strings.HasPrefix(ep.Host, "api.") && isTenancyHost(strings.TrimPrefix(ep.Hostname(), "api.")):
+		return fmt.Sprintf("%s://uploads.%s", ep.Scheme, strings.TrimPrefix(ep.Host, "api.")), fmt.Sprintf("%s://%s/graphql", ep.Scheme, ep.Host)
+	default:
+		return fmt.Sprintf("%s://%s/api/uploads", ep.Scheme, ep.Host), fmt.Sprintf("%s://%s/api/graphql", ep.Scheme, ep.Host)
+	}
+}
+
+// isTenancyHost reports whether the host is GitHub Enterprise Cloud with data residency ( SUBDOMAIN.ghe.com ).
+func isTenancyHost(host string) bool {
+	return strings.HasSuffix(host, tenancyHostSuffix) && !strings.Contains(strings.TrimSuffix(host, tenancyHostSuffix), ".")
+}
@@ -2,11 +2,21 @@ package apptest
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-github/v36/github"
 	"github.com/k1LoW/go-github-client/v36/factory"
 	"github.com/k1LoW/httpstub"
 )
@@ -103,3 +113,483 @@ func TestAuthUsingGitHubAppNoInstallationID(t *testing.T) {
 		}
 	})
 }
+
+func TestAuthUsingGitHubAppOptions(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", "")
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", "")
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", testInstallationID)).ResponseString(http.StatusOK, `{}`)
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/users/%s/repos", testOwner)).ResponseString(http.StatusOK, `[]`)
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		c, err := factory.NewGithubClient(
+			factory.AppID(testAppID),
+			factory.InstallationID(testInstallationID),
+			factory.PrivateKey([]byte(testPrivateKey)),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.Repositories.List(context.Background(), testOwner, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestNewGithubAppClient(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", strconv.Itoa(testAppID))
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", testPrivateKey)
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodGet).Path("/app/installations").Handler(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, `[{"id": %d, "account": {"login": "%s"}}]`, testInstallationID, testOwner)
+	})
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		c, err := factory.NewGithubAppClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		is, _, err := c.Apps.ListInstallations(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(is) != 1 {
+			t.Fatalf("got %v\nwant %v", len(is), 1)
+		}
+		if got := is[0].GetID(); got != testInstallationID {
+			t.Errorf("got %v\nwant %v", got, testInstallationID)
+		}
+	})
+}
+
+func TestInstallationPool(t *testing.T) {
+	t.Setenv("GITHUB_APP_ID", strconv.Itoa(testAppID))
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", testPrivateKey)
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	const otherInstallationID = 3
+	var lookups atomic.Int64
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/repos/%s/%s/installation", testOwner, testRepo)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, `{"id": %d}`, testInstallationID)
+	})
+	r.Method(http.MethodGet).Path("/app/installations").Handler(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, `[{"id": %d, "account": {"login": "other"}}]`, otherInstallationID)
+	})
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", testInstallationID)).ResponseString(http.StatusOK, `{"token": "ghs_example"}`)
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", otherInstallationID)).ResponseString(http.StatusOK, `{"token": "ghs_other"}`)
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/users/%s/repos", testOwner)).ResponseString(http.StatusOK, `[]`)
+	r.Method(http.MethodGet).Path("/users/other/repos").ResponseString(http.StatusOK, `[]`)
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		ctx := context.Background()
+		p, err := factory.NewInstallationPool()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 2 {
+			c, err := p.ForRepo(ctx, fmt.Sprintf("%s/%s", testOwner, testRepo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := c.Repositories.List(ctx, testOwner, nil); err != nil {
+				t.Error(err)
+			}
+			c, err = p.ForOwner(ctx, "other")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := c.Repositories.List(ctx, "other", nil); err != nil {
+				t.Error(err)
+			}
+		}
+		if got := lookups.Load(); got != 2 {
+			t.Errorf("got %v\nwant %v", got, 2)
+		}
+		if _, err := p.ForRepo(ctx, "invalid"); err == nil {
+			t.Error("want error")
+		}
+	})
+}
+
+func TestInstallationPoolSharesConnections(t *testing.T) {
+	t.Setenv("GITHUB_APP_ID", strconv.Itoa(testAppID))
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", testPrivateKey)
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	var (
+		mu    sync.Mutex
+		conns = map[string]struct{}{}
+	)
+	record := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		conns[r.RemoteAddr] = struct{}{}
+	}
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", testInstallationID)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		_, _ = w.Write([]byte(`{"token": "ghs_example"}`))
+	})
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/users/%s/repos", testOwner)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		_, _ = w.Write([]byte(`[]`))
+	})
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		ctx := context.Background()
+		p, err := factory.NewInstallationPool()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := p.ForInstallation(testInstallationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 2 {
+			if _, _, err := c.Repositories.List(ctx, testOwner, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Minting the installation token and calling the API use the same connection
+		mu.Lock()
+		defer mu.Unlock()
+		if got := len(conns); got != 1 {
+			t.Errorf("got %v connections\nwant %v", got, 1)
+		}
+	})
+}
+
+func TestAuthUsingGitHubAppScopedToken(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", strconv.Itoa(testAppID))
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", strconv.Itoa(testInstallationID))
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", testPrivateKey)
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", testInstallationID)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		got := struct {
+			Repositories []string          `json:"repositories"`
+			Permissions  map[string]string `json:"permissions"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		if want := []string{testRepo}; !reflect.DeepEqual(got.Repositories, want) {
+			t.Errorf("got %v\nwant %v", got.Repositories, want)
+		}
+		if want := map[string]string{"contents": "read"}; !reflect.DeepEqual(got.Permissions, want) {
+			t.Errorf("got %v\nwant %v", got.Permissions, want)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/users/%s/repos", testOwner)).ResponseString(http.StatusOK, `[]`)
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		c, err := factory.NewGithubClient(
+			factory.InstallationRepositories(testRepo),
+			factory.InstallationPermissions(&github.InstallationPermissions{Contents: github.String("read")}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.Repositories.List(context.Background(), testOwner, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestAuthUsingGitHubAppAuthorizationHeader(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", strconv.Itoa(testAppID))
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", strconv.Itoa(testInstallationID))
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", testPrivateKey)
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", testInstallationID)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-Gateway-Auth"); !strings.HasPrefix(got, "Bearer ") {
+			t.Errorf("got %v\nwant %v", got, "Bearer <JWT>")
+		}
+		if got := r.Header.Get("X-Tenant"); got != "example" {
+			t.Errorf("got %v\nwant %v", got, "example")
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"token": "ghs_example"}`))
+	})
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/users/%s/repos", testOwner)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("X-Gateway-Auth"), "Bearer ghs_example"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("got %v\nwant %v", got, "")
+		}
+		if got := r.Header.Get("X-Tenant"); got != "example" {
+			t.Errorf("got %v\nwant %v", got, "example")
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`[]`))
+	})
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		c, err := factory.NewGithubClient(
+			factory.AuthorizationHeader("X-Gateway-Auth"),
+			factory.AuthorizationScheme("Bearer"),
+			factory.DefaultHeader("X-Tenant", "example"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.Repositories.List(context.Background(), testOwner, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestContextCredentials(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", strconv.Itoa(testAppID))
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", testPrivateKey)
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	const otherInstallationID = 3
+	var tokenRequests atomic.Int64
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", testInstallationID)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"token": "ghs_example", "expires_at": "2099-01-01T00:00:00Z"}`))
+	})
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", otherInstallationID)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"token": "ghs_other", "expires_at": "2099-01-01T00:00:00Z"}`))
+	})
+	r.Method(http.MethodGet).Path("/user").Handler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"login": %q}`, r.Header.Get("Authorization"))))
+	})
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		c, err := factory.NewGithubClient(factory.ContextCredentials())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			ctx  context.Context
+			want string
+		}{
+			{factory.WithInstallationID(context.Background(), testInstallationID), "token ghs_example"},
+			{factory.WithInstallationID(context.Background(), otherInstallationID), "token ghs_other"},
+			{factory.WithInstallationID(context.Background(), testInstallationID), "token ghs_example"},
+			{factory.WithToken(context.Background(), "ghu_user"), "token ghu_user"},
+		}
+		for _, tt := range tests {
+			u, _, err := c.Users.Get(tt.ctx, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := u.GetLogin(); got != tt.want {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		}
+		if got := tokenRequests.Load(); got != 2 {
+			t.Errorf("got %v\nwant %v", got, 2)
+		}
+	})
+}
+
+func TestAuthUsingGitHubAppSigner(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", "")
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", "")
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/app/installations/%d/access_tokens", testInstallationID)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		claims := &jwt.RegisteredClaims{}
+		if _, err := jwt.ParseWithClaims(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), claims, func(token *jwt.Token) (any, error) {
+			return &key.PublicKey, nil
+		}); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if got, want := claims.Issuer, strconv.Itoa(testAppID); got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"token": "ghs_example"}`))
+	})
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/users/%s/repos", testOwner)).ResponseString(http.StatusOK, `[]`)
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		c, err := factory.NewGithubClient(
+			factory.AppID(testAppID),
+			factory.InstallationID(testInstallationID),
+			factory.AppSigner(key),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.Repositories.List(context.Background(), testOwner, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestAuthUsingGitHubAppProxy(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", "")
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", "")
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	const host = "ghes.example.test"
+	// The stub server works as the proxy
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodPost).Path(fmt.Sprintf("/api/v3/app/installations/%d/access_tokens", testInstallationID)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != host {
+			t.Errorf("got %v\nwant %v", r.URL.Host, host)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"token": "ghs_example"}`))
+	})
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/api/v3/users/%s/repos", testOwner)).Handler(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != host {
+			t.Errorf("got %v\nwant %v", r.URL.Host, host)
+		}
+		if got, want := r.Header.Get("Authorization"), "token ghs_example"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		_, _ = w.Write([]byte(`[]`))
+	})
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		c, err := factory.NewGithubClient(
+			factory.Endpoint(fmt.Sprintf("http://%s/api/v3", host)),
+			factory.AppID(testAppID),
+			factory.InstallationID(testInstallationID),
+			factory.PrivateKey([]byte(testPrivateKey)),
+			factory.Proxy(ts.URL),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.Repositories.List(context.Background(), testOwner, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestAuthUsingGitHubAppInstallationNotFound(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", strconv.Itoa(testAppID))
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", testPrivateKey)
+	t.Setenv("GITHUB_REPOSITORY", fmt.Sprintf("%s/%s", testOwner, testRepo))
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodGet).Path(fmt.Sprintf("/repos/%s/%s/installation", testOwner, testRepo)).ResponseString(http.StatusNotFound, `{"message": "Not Found"}`)
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Run("t", func(t *testing.T) {
+		t.Parallel() // to set GH_CONFIG_DIR and create new config
+		_, err := factory.NewGithubClient()
+		if err == nil {
+			t.Fatal("want error")
+		}
+		var ae *factory.AppAuthError
+		if !errors.As(err, &ae) {
+			t.Fatalf("got %T\nwant %T", err, ae)
+		}
+		if ae.AppID != testAppID {
+			t.Errorf("got %v\nwant %v", ae.AppID, testAppID)
+		}
+		var ne *factory.InstallationNotFoundError
+		if !errors.As(err, &ne) {
+			t.Fatalf("got %T\nwant %T", err, ne)
+		}
+		if ne.Owner != testOwner || ne.Repo != testRepo {
+			t.Errorf("got %v/%v\nwant %v/%v", ne.Owner, ne.Repo, testOwner, testRepo)
+		}
+	})
+}
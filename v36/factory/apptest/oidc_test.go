@@ -0,0 +1,104 @@
+package apptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/k1LoW/go-github-client/v36/factory"
+	"github.com/k1LoW/httpstub"
+)
+
+const (
+	testRequestToken = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+	testIDToken      = "OIDC_ID_TOKEN"
+	testAudience     = "example-broker"
+	testOIDCToken    = "ghs_EXCHANGED"
+)
+
+func TestOIDCTokenExchange(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GH_CONFIG_DIR", "/tmp")
+	r := httpstub.NewRouter(t)
+	r.Method(http.MethodGet).Path("/idtoken").Handler(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer "+testRequestToken; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if got := r.URL.Query().Get("audience"); got != testAudience {
+			t.Errorf("got %v\nwant %v", got, testAudience)
+		}
+		_, _ = fmt.Fprintf(w, `{"value": "%s"}`, testIDToken)
+	})
+	r.Method(http.MethodPost).Path("/exchange").Handler(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer "+testIDToken; got != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"token": "%s"}`, testOIDCToken)
+	})
+	r.Method(http.MethodPost).Path("/error").ResponseString(http.StatusInternalServerError, `{}`)
+	r.Method(http.MethodGet).Path("/users/octocat").Handler(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "token "+testOIDCToken; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		_, _ = w.Write([]byte(`{"login": "octocat"}`))
+	})
+	ts := r.Server()
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	t.Setenv("GITHUB_API_URL", ts.URL)
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", ts.URL+"/idtoken?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", testRequestToken)
+
+	t.Run("exchange", func(t *testing.T) {
+		c, err := factory.NewGithubClient(factory.OIDCTokenExchange(ts.URL+"/exchange", testAudience))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.Users.Get(context.Background(), "octocat"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("broker error", func(t *testing.T) {
+		if _, err := factory.NewGithubClient(factory.OIDCTokenExchange(ts.URL+"/error", testAudience)); err == nil {
+			t.Error("want error")
+		}
+	})
+
+	t.Run("not in GitHub Actions", func(t *testing.T) {
+		t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", "")
+		t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "")
+		file := filepath.Join(t.TempDir(), "token")
+		if err := os.WriteFile(file, []byte("ghp_FROM_FILE\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// The next credential source is tried
+		d, err := factory.Detect(factory.OIDCTokenExchange(ts.URL+"/exchange", testAudience), factory.CredentialChain(factory.TokenFile(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Token != "ghp_FROM_FILE" {
+			t.Errorf("got %v\nwant %v", d.Token, "ghp_FROM_FILE")
+		}
+		if d.TokenSource != file {
+			t.Errorf("got %v\nwant %v", d.TokenSource, file)
+		}
+
+		_, err = factory.Detect(factory.OIDCTokenExchange(ts.URL+"/exchange", testAudience), factory.CredentialChain(factory.TokenFile(filepath.Join(t.TempDir(), "notfound"))))
+		if !errors.Is(err, factory.ErrNoCredentials) {
+			t.Fatalf("got %v\nwant %v", err, factory.ErrNoCredentials)
+		}
+		if !strings.Contains(err.Error(), "factory.OIDCTokenExchange") {
+			t.Errorf("got %v\nwant the tried sources including factory.OIDCTokenExchange", err)
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"weak"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v36/github"
+)
+
+// TokenType is the type of token.
+type TokenType string
+
+// Types of token.
+const (
+	TokenTypePersonalAccessToken            TokenType = "personal_access_token"
+	TokenTypeFineGrainedPersonalAccessToken TokenType = "fine_grained_personal_access_token"
+	TokenTypeOAuth                          TokenType = "oauth"
+	TokenTypeUserToServer                   TokenType = "user_to_server"
+	TokenTypeInstallation                   TokenType = "installation"
+	TokenTypeAnonymous                      TokenType = "anonymous"
+	TokenTypeUnknown                        TokenType = "unknown"
+)
+
+const tokenExpirationHeader = "github-authentication-token-expiration"
+
+// CredentialInfo is information about the credential used by the client.
+type CredentialInfo struct {
+	// Login is the login of the authenticated user. It is empty for GitHub App installations and anonymous access.
+	Login     string
+	TokenType TokenType
+	// Scopes is the OAuth scopes of the token ( X-OAuth-Scopes ). Fine-grained tokens and installation tokens have no scopes.
+	Scopes []string
+	// AcceptedScopes is the OAuth scopes that the preflight endpoint accepts ( X-Accepted-OAuth-Scopes ).
+	AcceptedScopes []string
+	// ExpiresAt is the expiration time of the token. It is zero if the token does not expire or the expiration is unknown.
+	ExpiresAt time.Time
+}
+
+// PreflightError is the error returned when the preflight validation of the credential fails.
+type PreflightError struct {
+	// Endpoint is the path of the API called for the validation.
+	Endpoint string
+	// StatusCode is the HTTP status code of the response. It is 0 if no response was received.
+	StatusCode int
+	Err        error
+}
+
+func (e *PreflightError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("preflight validation of the credential failed ( GET /%s ): %v", e.Endpoint, e.Err)
+	}
+	return fmt.Sprintf("preflight validation of the credential failed ( GET /%s: %d ): %v", e.Endpoint, e.StatusCode, e.Err)
+}
+
+func (e *PreflightError) Unwrap() error {
+	return e.Err
+}
+
+// Preflight enables to validate the credential by calling the API at construction.
+// The result can be retrieved using GetCredentialInfo.
+func Preflight() Option {
+	return func(c *Config) error {
+		c.Preflight = true
+		return nil
+	}
+}
+
+// TokenExpirationWarning sets the callback called when the token is within the duration of expiring.
+// The expiration is taken from github-authentication-token-expiration header of responses.
+// If fn is nil, a warning is printed to stderr.
+func TokenExpirationWarning(within time.Duration, fn func(expiresAt time.Time)) Option {
+	return func(c *Config) error {
+		if within <= 0 {
+			return nil
+		}
+		c.TokenExpirationWarning = within
+		c.OnTokenExpirationWarning = fn
+		return nil
+	}
+}
+
+// GetCredentialInfo returns information about the credential used by the client built by the factory.
+func GetCredentialInfo(c *github.Client) (*CredentialInfo, bool) {
+	s, ok := lookupClientState(c)
+	if !ok {
+		return nil, false
+	}
+	return s.credentialInfo(), true
+}
+
+type clientState struct {
+	mu                sync.RWMutex
+	info              CredentialInfo
+	expirationWarning time.Duration
+	onExpiration      func(expiresAt time.Time)
+	warnedExpiresAt   time.Time
+	// contextClient is http.Client shared by the clients of tenants ( NewTenantClient ).
+	contextClient *http.Client
+}
+
+func newClientState(c *Config) *clientState {
+	s := &clientState{
+		expirationWarning: c.TokenExpirationWarning,
+		onExpiration:      c.OnTokenExpirationWarning,
+	}
+	if s.expirationWarning > 0 && s.onExpiration == nil {
+		s.onExpiration = func(expiresAt time.Time) {
+			_, _ = fmt.Fprintf(os.Stderr, "warning: the GitHub token expires at %s\n", expiresAt.Format(time.RFC3339))
+		}
+	}
+	return s
+}
+
+// observe captures the token expiration from the response and calls the callback when the token is about to expire.
+func (s *clientState) observe(res *http.Response) {
+	expiresAt, ok := parseTokenExpiration(res.Header.Get(tokenExpirationHeader))
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.info.ExpiresAt = expiresAt
+	warn := s.expirationWarning > 0 && time.Until(expiresAt) <= s.expirationWarning && !s.warnedExpiresAt.Equal(expiresAt)
+	if warn {
+		s.warnedExpiresAt = expiresAt
+	}
+	s.mu.Unlock()
+	if warn {
+		s.onExpiration(expiresAt)
+	}
+}
+
+func (s *clientState) credentialInfo() *CredentialInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info := s.info
+	info.Scopes = append([]string(nil), s.info.Scopes...)
+	info.AcceptedScopes = append([]string(nil), s.info.AcceptedScopes...)
+	return &info
+}
+
+var clientStates sync.Map // weak.Pointer[github.Client] -> *clientState
+
+func registerClientState(c *github.Client, s *clientState) {
+	k := weak.Make(c)
+	clientStates.Store(k, s)
+	runtime.AddCleanup(c, func(k weak.Pointer[github.Client]) {
+		clientStates.Delete(k)
+	}, k)
+}
+
+func lookupClientState(c *github.Client) (*clientState, bool) {
+	if c == nil {
+		return nil, false
+	}
+	v, ok := clientStates.Load(weak.Make(c))
+	if !ok {
+		return nil, false
+	}
+	return v.(*clientState), true //nolint:forcetypeassert
+}
+
+// detectTokenType detects the type of the token by the prefix.
+// ref: https://github.blog/engineering/platform-security/behind-githubs-new-authentication-token-formats/
+func detectTokenType(token string) TokenType {
+	switch {
+	case token == "":
+		return TokenTypeAnonymous
+	case strings.HasPrefix(token, "ghp_"):
+		return TokenTypePersonalAccessToken
+	case strings.HasPrefix(token, "github_pat_"):
+		return TokenTypeFineGrainedPersonalAccessToken
+	case strings.HasPrefix(token, "gho_"):
+		return TokenTypeOAuth
+	case strings.HasPrefix(token, "ghu_"):
+		return TokenTypeUserToServer
+	case strings.HasPrefix(token, "ghs_"):
+		return TokenTypeInstallation
+	default:
+		return TokenTypeUnknown
+	}
+}
+
+// preflight validates the credential by calling the API and updates the credential info.
+func preflight(ctx context.Context, v3c *github.Client, hc *http.Client, s *clientState) error {
+	s.mu.RLock()
+	tokenType := s.info.TokenType
+	s.mu.RUnlock()
+	var (
+		endpoint string
+		u        *github.User
+		res      *github.Response
+		err      error
+	)
+	switch tokenType {
+	case TokenTypeInstallation:
+		endpoint = "installation/repositories?per_page=1"
+		_, res, err = v3c.Apps.ListRepos(ctx, &github.ListOptions{PerPage: 1})
+	case TokenTypeAnonymous:
+		endpoint = "rate_limit"
+		_, res, err = v3c.RateLimits(ctx)
+	default:
+		endpoint = "user"
+		u, res, err = v3c.Users.Get(ctx, "")
+	}
+	if err != nil {
+		e := &PreflightError{Endpoint: endpoint, Err: err}
+		if res != nil {
+			e.StatusCode = res.StatusCode
+		}
+		return e
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info.Login = u.GetLogin()
+	s.info.Scopes = splitScopes(res.Header.Get("X-OAuth-Scopes"))
+	s.info.AcceptedScopes = splitScopes(res.Header.Get("X-Accepted-OAuth-Scopes"))
+	if exp, ok := parseTokenExpiration(res.Header.Get(tokenExpirationHeader)); ok {
+		s.info.ExpiresAt = exp
+	}
+	var itr *ghinstallation.Transport
+	switch t := hc.Transport.(type) {
+	case *ghinstallation.Transport:
+		itr = t
+	case *roundTripper:
+		itr = t.itr
+	}
+	if itr != nil {
+		if exp, _, err := itr.Expiry(); err == nil {
+			s.info.ExpiresAt = exp
+		}
+	}
+	return nil
+}
+
+func splitScopes(in string) []string {
+	var scopes []string
+	for _, s := range strings.Split(in, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// parseTokenExpiration parses the value of github-authentication-token-expiration header ( e.g. "2023-04-12 12:00:00 -0700", "2023-04-12 12:00:00 UTC" ).
+func parseTokenExpiration(in string) (time.Time, bool) {
+	if in == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05 -0700", "2006-01-02 15:04:05 MST"} {
+		if t, err := time.Parse(layout, in); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -1,13 +1,37 @@
 package factory
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/cli/go-gh/v2/pkg/config"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/go-github/v36/github"
 	"github.com/migueleliasweb/go-github-mock/src/mock"
 )
@@ -39,6 +63,8 @@ func TestGetTokenAndEndpoints(t *testing.T) {
 		{"", "", "", "GH_TOKEN", "", "", "GH_TOKEN", "https://api.github.com", "https://uploads.github.com", "https://api.github.com/graphql"},
 		{"", "", "", "", "GITHUB_TOKEN", "", "GITHUB_TOKEN", "https://api.github.com", "https://uploads.github.com", "https://api.github.com/graphql"},
 		{"", "", "", "", "", "GITHUB_API_URL", "gho_XXXXXxxxxXXXXxxxXXXXXX", "GITHUB_API_URL", "https://uploads.github.com", "https://api.github.com/graphql"},
+		{"example.ghe.com", "", "", "GH_TOKEN", "", "", "GH_TOKEN", "https://api.example.ghe.com", "https://uploads.example.ghe.com", "https://api.example.ghe.com/graphql"},
+		{"", "", "", "", "", "https://api.example.ghe.com", "gho_XXXXXxxxxXXXXxxxXXXXXX", "https://api.example.ghe.com", "https://uploads.example.ghe.com", "https://api.example.ghe.com/graphql"},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
@@ -83,6 +109,8 @@ func TestEndpoint(t *testing.T) {
 		{"git.example.com", "GH_ENTERPRISE_TOKEN", "", "", "", "https://git.example.com/api/v3/", "https://git.example.com/api/uploads/"},
 		{"", "", "", "", "https://git.example.com/api/v3", "https://git.example.com/api/v3/", "https://git.example.com/api/uploads/"},
 		{"", "", "", "", "https://api.github.com", "https://api.github.com/", "https://uploads.github.com/"},
+		{"example.ghe.com", "", "", "GH_TOKEN", "", "https://api.example.ghe.com/", "https://uploads.example.ghe.com/"},
+		{"", "", "", "", "https://api.example.ghe.com", "https://api.example.ghe.com/", "https://uploads.example.ghe.com/"},
 	}
 	t.Setenv("GITHUB_TOKEN", "GITHUB_TOKEN")
 
@@ -190,6 +218,1583 @@ func TestDetectOwnerRepo(t *testing.T) {
 	}
 }
 
+func TestAppCredentials(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "app.pem")
+	if err := os.WriteFile(keyFile, []byte("KEY_FROM_FILE"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts                       []Option
+		GITHUB_APP_ID              string
+		GITHUB_APP_INSTALLATION_ID string
+		GITHUB_APP_PRIVATE_KEY     string
+		wantAppID                  int64
+		wantInstallationID         int64
+		wantPrivateKey             string
+		wantErr                    bool
+	}{
+		{nil, "", "", "", 0, 0, "", true},
+		{nil, "1", "", "KEY_FROM_ENV", 1, 0, "KEY_FROM_ENV", false},
+		{nil, "1", "2", "KEY_FROM_ENV", 1, 2, "KEY_FROM_ENV", false},
+		{nil, "invalid", "2", "KEY_FROM_ENV", 0, 0, "", true},
+		{[]Option{AppID(3), InstallationID(4), PrivateKey([]byte("KEY_FROM_OPTION"))}, "1", "2", "KEY_FROM_ENV", 3, 4, "KEY_FROM_OPTION", false},
+		{[]Option{AppID(3), PrivateKeyFile(keyFile)}, "", "", "", 3, 0, "KEY_FROM_FILE", false},
+		{[]Option{AppID(3)}, "", "", "", 0, 0, "", true},
+		{[]Option{PrivateKey([]byte("KEY_FROM_OPTION"))}, "1", "", "", 1, 0, "KEY_FROM_OPTION", false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Setenv("GITHUB_APP_ID", tt.GITHUB_APP_ID)
+			t.Setenv("GITHUB_APP_INSTALLATION_ID", tt.GITHUB_APP_INSTALLATION_ID)
+			t.Setenv("GITHUB_APP_PRIVATE_KEY", tt.GITHUB_APP_PRIVATE_KEY)
+			c := &Config{}
+			for _, o := range tt.opts {
+				if err := o(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			gotAppID, gotInstallationID, gotPrivateKey, err := appCredentials(c)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("got error: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Error("want error")
+			}
+			if gotAppID != tt.wantAppID {
+				t.Errorf("got %v\nwant %v", gotAppID, tt.wantAppID)
+			}
+			if gotInstallationID != tt.wantInstallationID {
+				t.Errorf("got %v\nwant %v", gotInstallationID, tt.wantInstallationID)
+			}
+			if string(gotPrivateKey) != tt.wantPrivateKey {
+				t.Errorf("got %v\nwant %v", string(gotPrivateKey), tt.wantPrivateKey)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyFileNotFound(t *testing.T) {
+	if _, err := NewGithubClient(PrivateKeyFile(filepath.Join(t.TempDir(), "notfound.pem"))); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestAppSigner(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "app.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileSigner, err := NewFileSigner(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		signer  crypto.Signer
+		wantErr bool
+	}{
+		{key, false},
+		{fileSigner, false},
+		{ecKey, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			c := &Config{}
+			if err := AppID(1)(c); err != nil {
+				t.Fatal(err)
+			}
+			if err := AppSigner(tt.signer)(c); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, _, err := appCredentials(c); err != nil {
+				t.Fatal(err)
+			}
+			s := &jwtSigner{signer: c.AppSigner}
+			ss, err := s.Sign(&jwt.RegisteredClaims{Issuer: "1"})
+			if err != nil {
+				if !tt.wantErr {
+					t.Error(err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("want error")
+			}
+			claims := &jwt.RegisteredClaims{}
+			if _, err := jwt.ParseWithClaims(ss, claims, func(token *jwt.Token) (any, error) {
+				if token.Method != jwt.SigningMethodRS256 {
+					return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
+				}
+				return &key.PublicKey, nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if claims.Issuer != "1" {
+				t.Errorf("got %v\nwant %v", claims.Issuer, "1")
+			}
+		})
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	const (
+		clientID   = "CLIENT_ID"
+		deviceCode = "DEVICE_CODE"
+		userCode   = "WDJB-MJHT"
+		token      = "gho_DEVICE_FLOW"
+		revoked    = "gho_REVOKED"
+	)
+	const interval = 50 * time.Millisecond
+	var polls atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/device/code", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("client_id"); got != clientID {
+			t.Errorf("got %v\nwant %v", got, clientID)
+		}
+		if got, want := r.FormValue("scope"), "repo read:org"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		_, _ = fmt.Fprintf(w, `{"device_code": "%s", "user_code": "%s", "verification_uri": "https://github.com/login/device", "expires_in": 900, "interval": 0}`, deviceCode, userCode)
+	})
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("device_code"); got != deviceCode {
+			t.Errorf("got %v\nwant %v", got, deviceCode)
+		}
+		if polls.Add(1) == 1 {
+			_, _ = w.Write([]byte(`{"error": "authorization_pending"}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"access_token": "%s", "token_type": "bearer", "scope": "repo,read:org"}`, token)
+	})
+	mux.HandleFunc("/users/octocat", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "token "+token; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		_, _ = w.Write([]byte(`{"login": "octocat"}`))
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		switch got := r.Header.Get("Authorization"); got {
+		case "token " + token:
+			_, _ = w.Write([]byte(`{"login": "octocat"}`))
+		case "token " + revoked:
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			t.Errorf("unexpected token: %v", got)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	t.Setenv("GH_HOST", "deviceflow.example.com") // host without token
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_APP_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", "")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	newClient := func(t *testing.T) *bytes.Buffer {
+		t.Helper()
+		out := new(bytes.Buffer)
+		c, err := NewGithubClient(
+			Endpoint(ts.URL),
+			DeviceFlow(clientID, "repo", "read:org"),
+			DeviceFlowTokenFile(tokenFile),
+			DeviceFlowOutput(out),
+			func(c *Config) error {
+				c.DeviceFlowInterval = interval
+				return nil
+			},
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.Users.Get(context.Background(), "octocat"); err != nil {
+			t.Error(err)
+		}
+		return out
+	}
+	writeTokenFile := func(t *testing.T, host, token string) {
+		t.Helper()
+		if err := os.WriteFile(tokenFile, []byte(fmt.Sprintf(`{"host": %q, "access_token": %q}`, host, token)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	if out := newClient(t); !strings.Contains(out.String(), userCode) {
+		t.Errorf("got %v\nwant the user code %v", out.String(), userCode)
+	}
+	if out := newClient(t); out.Len() != 0 {
+		t.Errorf("got %v\nwant the saved token to be reused", out.String())
+	}
+	if got := polls.Load(); got != 2 {
+		t.Errorf("got %v\nwant %v", got, 2)
+	}
+	// The response has no interval, so polling waits for the default interval
+	if elapsed := time.Since(start); elapsed < 2*interval {
+		t.Errorf("got %v\nwant >= %v", elapsed, 2*interval)
+	}
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), fmt.Sprintf(`{"host":%q,"access_token":%q}`, host, token); got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	tests := []struct {
+		name  string
+		host  string
+		token string
+	}{
+		{"revoked token", host, revoked},
+		{"token for another host", "github.com", "gho_ANOTHER_HOST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeTokenFile(t, tt.host, tt.token)
+			before := polls.Load()
+			if out := newClient(t); !strings.Contains(out.String(), userCode) {
+				t.Errorf("got %v\nwant the user code %v", out.String(), userCode)
+			}
+			if got := polls.Load(); got != before+1 {
+				t.Errorf("got %v\nwant %v", got, before+1)
+			}
+			b, err := os.ReadFile(tokenFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), token) {
+				t.Errorf("got %v\nwant %v", string(b), token)
+			}
+		})
+	}
+}
+
+func TestOAuthBaseURL(t *testing.T) {
+	tests := []struct {
+		ep      string
+		want    string
+		wantErr bool
+	}{
+		{"https://api.github.com", "https://github.com", false},
+		{"https://git.example.com/api/v3", "https://git.example.com", false},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080", false},
+		{"https://api.example.ghe.com", "https://example.ghe.com", false},
+		{"api.github.com", "", true},
+	}
+	for _, tt := range tests {
+		got, err := oauthBaseURL(tt.ep)
+		if err != nil {
+			if !tt.wantErr {
+				t.Errorf("got error: %v", err)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("got %v\nwant %v", got, tt.want)
+		}
+	}
+}
+
+func TestRefreshableUserToken(t *testing.T) {
+	t.Setenv("GITHUB_APP_ID", "")
+	tests := []struct {
+		token         UserAccessToken
+		wantRefreshes int
+	}{
+		{UserAccessToken{AccessToken: "ghu_VALID", ExpiresAt: time.Now().Add(time.Hour), RefreshToken: "ghr_OLD"}, 0},
+		{UserAccessToken{AccessToken: "ghu_EXPIRED", ExpiresAt: time.Now().Add(-time.Hour), RefreshToken: "ghr_OLD"}, 1},
+		{UserAccessToken{AccessToken: "ghu_REVOKED", ExpiresAt: time.Now().Add(time.Hour), RefreshToken: "ghr_OLD"}, 1},
+		{UserAccessToken{AccessToken: "ghu_REVOKED", RefreshToken: "ghr_OLD"}, 1},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Fatal(err)
+				}
+				want := url.Values{
+					"client_id":     {"CLIENT_ID"},
+					"client_secret": {"CLIENT_SECRET"},
+					"grant_type":    {"refresh_token"},
+					"refresh_token": {"ghr_OLD"},
+				}
+				if !reflect.DeepEqual(r.PostForm, want) {
+					t.Errorf("got %v\nwant %v", r.PostForm, want)
+				}
+				_, _ = w.Write([]byte(`{"access_token": "ghu_VALID", "expires_in": 28800, "refresh_token": "ghr_NEW", "refresh_token_expires_in": 15897600}`))
+			})
+			mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "token ghu_VALID" {
+					w.WriteHeader(http.StatusUnauthorized)
+					_, _ = w.Write([]byte(`{"message": "Bad credentials"}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"login": "octocat"}`))
+			})
+			ts := httptest.NewServer(mux)
+			t.Cleanup(ts.Close)
+
+			var refreshed []UserAccessToken
+			c, err := NewGithubClient(Endpoint(ts.URL), RefreshableUserToken(tt.token, "CLIENT_ID", "CLIENT_SECRET", func(t UserAccessToken) {
+				refreshed = append(refreshed, t)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				if _, _, err := c.Users.Get(context.Background(), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(refreshed) != tt.wantRefreshes {
+				t.Fatalf("got %v\nwant %v", len(refreshed), tt.wantRefreshes)
+			}
+			for _, got := range refreshed {
+				if got.AccessToken != "ghu_VALID" || got.RefreshToken != "ghr_NEW" || got.ExpiresAt.IsZero() || got.RefreshTokenExpiresAt.IsZero() {
+					t.Errorf("got %#v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveToken(t *testing.T) {
+	t.Setenv("GH_HOST", "")
+	t.Setenv("GH_TOKEN", "GH_TOKEN")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("FILE_TOKEN\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	notExistFile := filepath.Join(t.TempDir(), "notexist")
+	errSource := TokenSourceFunc(func(_ context.Context, _ string) (string, string, error) {
+		return "", "error", errors.New("error")
+	})
+	tests := []struct {
+		opts            []Option
+		wantToken       string
+		wantTokenSource string
+		wantErr         bool
+	}{
+		{nil, "GH_TOKEN", "GH_TOKEN", false},
+		{[]Option{Token("TOKEN")}, "TOKEN", "factory.Token", false},
+		{[]Option{Token("TOKEN"), CredentialChain(StaticToken("STATIC_TOKEN"))}, "TOKEN", "factory.Token", false},
+		{[]Option{CredentialChain(TokenFile(notExistFile), StaticToken("STATIC_TOKEN"))}, "STATIC_TOKEN", "static", false},
+		{[]Option{CredentialChain(TokenFile(tokenFile), StaticToken("STATIC_TOKEN"))}, "FILE_TOKEN", tokenFile, false},
+		{[]Option{CredentialChain(TokenFile(notExistFile), GHToken())}, "GH_TOKEN", "GH_TOKEN", false},
+		{[]Option{CredentialChain(TokenFile(notExistFile))}, "", "", false},
+		{[]Option{CredentialChain(errSource, StaticToken("STATIC_TOKEN"))}, "", "", true},
+		{[]Option{CredentialChain(nil)}, "", "", true},
+		{[]Option{SkipAuth(true)}, "", "", false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			gotToken, gotTokenSource, err := ResolveToken(tt.opts...)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("got error: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Error("want error")
+			}
+			if gotToken != tt.wantToken {
+				t.Errorf("got %v\nwant %v", gotToken, tt.wantToken)
+			}
+			if gotTokenSource != tt.wantTokenSource {
+				t.Errorf("got %v\nwant %v", gotTokenSource, tt.wantTokenSource)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GITHUB_API_URL", "")
+	t.Setenv("GITHUB_GRAPHQL_URL", "")
+	t.Setenv("GITHUB_APP_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", "")
+	t.Setenv("GH_REPO", "")
+	t.Setenv("GITHUB_REPOSITORY", "example/myrepo")
+	t.Setenv("GITHUB_REPOSITORY_OWNER", "")
+	tests := []struct {
+		GH_HOST             string
+		GH_ENTERPRISE_TOKEN string
+		GITHUB_APP_ID       string
+		opts                []Option
+		want                *Detected
+		wantErr             bool
+	}{
+		{"git.example.com", "GH_ENTERPRISE_TOKEN", "", nil, &Detected{
+			Host:            "git.example.com",
+			HostSource:      "GH_HOST",
+			RESTEndpoint:    "https://git.example.com/api/v3",
+			UploadEndpoint:  "https://git.example.com/api/uploads",
+			GraphQLEndpoint: "https://git.example.com/api/graphql",
+			Token:           "GH_ENTERPRISE_TOKEN",
+			TokenSource:     "GH_ENTERPRISE_TOKEN",
+			Owner:           "example",
+			Repo:            "myrepo",
+			AuthMode:        AuthModeToken,
+		}, false},
+		{"git.example.com", "", "", []Option{Token("TOKEN"), Owner("owner")}, &Detected{
+			Host:            "git.example.com",
+			HostSource:      "GH_HOST",
+			RESTEndpoint:    "https://git.example.com/api/v3",
+			UploadEndpoint:  "https://git.example.com/api/uploads",
+			GraphQLEndpoint: "https://git.example.com/api/graphql",
+			Token:           "TOKEN",
+			TokenSource:     "factory.Token",
+			Owner:           "owner",
+			AuthMode:        AuthModeToken,
+		}, false},
+		{"git.example.com", "GH_ENTERPRISE_TOKEN", "", []Option{SkipAuth(true)}, &Detected{
+			Host:            "git.example.com",
+			HostSource:      "GH_HOST",
+			RESTEndpoint:    "https://git.example.com/api/v3",
+			UploadEndpoint:  "https://git.example.com/api/uploads",
+			GraphQLEndpoint: "https://git.example.com/api/graphql",
+			Owner:           "example",
+			Repo:            "myrepo",
+			AuthMode:        AuthModeAnonymous,
+		}, false},
+		{"git.example.com", "", "1", []Option{PrivateKey([]byte("KEY"))}, &Detected{
+			Host:            "git.example.com",
+			HostSource:      "GH_HOST",
+			RESTEndpoint:    "https://git.example.com/api/v3",
+			UploadEndpoint:  "https://git.example.com/api/uploads",
+			GraphQLEndpoint: "https://git.example.com/api/graphql",
+			Owner:           "example",
+			Repo:            "myrepo",
+			AuthMode:        AuthModeApp,
+			tried:           []string{"environment variables, gh config and gh command"},
+		}, false},
+		{"git.example.com", "GH_ENTERPRISE_TOKEN", "", []Option{AppID(1), PrivateKey([]byte("KEY"))}, &Detected{
+			Host:            "git.example.com",
+			HostSource:      "GH_HOST",
+			RESTEndpoint:    "https://git.example.com/api/v3",
+			UploadEndpoint:  "https://git.example.com/api/uploads",
+			GraphQLEndpoint: "https://git.example.com/api/graphql",
+			Owner:           "example",
+			Repo:            "myrepo",
+			AuthMode:        AuthModeApp,
+		}, false},
+		{"git.example.com", "", "", nil, nil, true},
+		{"git.example.com", "", "", []Option{AllowAnonymous()}, &Detected{
+			Host:              "git.example.com",
+			HostSource:        "GH_HOST",
+			RESTEndpoint:      "https://git.example.com/api/v3",
+			UploadEndpoint:    "https://git.example.com/api/uploads",
+			GraphQLEndpoint:   "https://git.example.com/api/graphql",
+			Owner:             "example",
+			Repo:              "myrepo",
+			AuthMode:          AuthModeAnonymous,
+			AnonymousFallback: true,
+		}, false},
+		{"git.example.com", "GH_ENTERPRISE_TOKEN", "", []Option{AllowAnonymous()}, &Detected{
+			Host:            "git.example.com",
+			HostSource:      "GH_HOST",
+			RESTEndpoint:    "https://git.example.com/api/v3",
+			UploadEndpoint:  "https://git.example.com/api/uploads",
+			GraphQLEndpoint: "https://git.example.com/api/graphql",
+			Token:           "GH_ENTERPRISE_TOKEN",
+			TokenSource:     "GH_ENTERPRISE_TOKEN",
+			Owner:           "example",
+			Repo:            "myrepo",
+			AuthMode:        AuthModeToken,
+		}, false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Setenv("GH_HOST", tt.GH_HOST)
+			t.Setenv("GH_ENTERPRISE_TOKEN", tt.GH_ENTERPRISE_TOKEN)
+			t.Setenv("GITHUB_APP_ID", tt.GITHUB_APP_ID)
+			got, err := Detect(tt.opts...)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("got error: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Error("want error")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialErrors(t *testing.T) {
+	t.Setenv("GH_HOST", "errors.example.com")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_ENTERPRISE_TOKEN", "")
+	t.Setenv("GITHUB_API_URL", "")
+	t.Setenv("GITHUB_APP_INSTALLATION_ID", "")
+	t.Setenv("GITHUB_APP_PRIVATE_KEY", "")
+	tests := []struct {
+		GITHUB_APP_ID    string
+		opts             []Option
+		wantNoCreds      bool
+		wantAppAuthError *AppAuthError
+		wantTried        []string
+	}{
+		{"", nil, true, nil, []string{"environment variables, gh config and gh command", "GitHub App"}},
+		{"", []Option{CredentialChain(TokenFile(filepath.Join(t.TempDir(), "notfound")))}, true, nil, []string{"notfound", "GitHub App"}},
+		{"invalid", nil, false, &AppAuthError{}, nil},
+		{"", []Option{AppID(1)}, false, &AppAuthError{AppID: 1}, nil},
+		{"invalid", []Option{AllowAnonymous()}, false, &AppAuthError{}, nil},
+		{"", []Option{AppID(1), AllowAnonymous()}, false, &AppAuthError{AppID: 1}, nil},
+		{"", []Option{AppID(1), DeviceFlow("CLIENT_ID")}, false, &AppAuthError{AppID: 1}, nil},
+		{"", []Option{AppID(1), InstallationID(2), PrivateKey([]byte("INVALID"))}, false, &AppAuthError{AppID: 1, InstallationID: 2}, nil},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Setenv("GITHUB_APP_ID", tt.GITHUB_APP_ID)
+			_, err := NewGithubClient(tt.opts...)
+			if err == nil {
+				t.Fatal("want error")
+			}
+			if got := errors.Is(err, ErrNoCredentials); got != tt.wantNoCreds {
+				t.Errorf("got %v\nwant %v", got, tt.wantNoCreds)
+			}
+			for _, tried := range tt.wantTried {
+				if !strings.Contains(err.Error(), tried) {
+					t.Errorf("got %q\nwant to contain %q", err.Error(), tried)
+				}
+			}
+			var ae *AppAuthError
+			if got := errors.As(err, &ae); got != (tt.wantAppAuthError != nil) {
+				t.Fatalf("got %v\nwant %v", got, tt.wantAppAuthError != nil)
+			}
+			if ae == nil {
+				return
+			}
+			if ae.AppID != tt.wantAppAuthError.AppID || ae.InstallationID != tt.wantAppAuthError.InstallationID {
+				t.Errorf("got %#v\nwant %#v", ae, tt.wantAppAuthError)
+			}
+			if ae.Err == nil {
+				t.Error("want cause")
+			}
+		})
+	}
+}
+
+func TestHost(t *testing.T) {
+	t.Setenv("GH_HOST", "")
+	t.Setenv("GH_TOKEN", "GH_TOKEN")
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "GH_ENTERPRISE_TOKEN")
+	t.Setenv("GITHUB_API_URL", "https://ghes.example.com/api/v3")
+	t.Setenv("GITHUB_GRAPHQL_URL", "https://ghes.example.com/api/graphql")
+	tests := []struct {
+		host string
+		want *Detected
+	}{
+		{"github.com", &Detected{
+			Host:            "github.com",
+			HostSource:      "factory.Host",
+			RESTEndpoint:    "https://api.github.com",
+			UploadEndpoint:  "https://uploads.github.com",
+			GraphQLEndpoint: "https://api.github.com/graphql",
+			Token:           "GH_TOKEN",
+			TokenSource:     "GH_TOKEN",
+			AuthMode:        AuthModeToken,
+		}},
+		{"Git.Example.com", &Detected{
+			Host:            "git.example.com",
+			HostSource:      "factory.Host",
+			RESTEndpoint:    "https://git.example.com/api/v3",
+			UploadEndpoint:  "https://git.example.com/api/uploads",
+			GraphQLEndpoint: "https://git.example.com/api/graphql",
+			Token:           "GH_ENTERPRISE_TOKEN",
+			TokenSource:     "GH_ENTERPRISE_TOKEN",
+			AuthMode:        AuthModeToken,
+		}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := Detect(Host(tt.host))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.Owner, got.Repo = "", ""
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostOfRepo(t *testing.T) {
+	t.Setenv("GH_HOST", "")
+	t.Setenv("GH_TOKEN", "GH_TOKEN")
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_ENTERPRISE_TOKEN", "GH_ENTERPRISE_TOKEN")
+	t.Setenv("GITHUB_API_URL", "")
+	t.Setenv("GITHUB_GRAPHQL_URL", "")
+	t.Setenv("GITHUB_REPOSITORY", "")
+	t.Setenv("GITHUB_REPOSITORY_OWNER", "")
+	ghes := &Detected{
+		Host:            "git.example.com",
+		RESTEndpoint:    "https://git.example.com/api/v3",
+		UploadEndpoint:  "https://git.example.com/api/uploads",
+		GraphQLEndpoint: "https://git.example.com/api/graphql",
+		Token:           "GH_ENTERPRISE_TOKEN",
+		TokenSource:     "GH_ENTERPRISE_TOKEN",
+		Owner:           "example",
+		Repo:            "myrepo",
+		AuthMode:        AuthModeToken,
+	}
+	tests := []struct {
+		GH_REPO        string
+		opts           []Option
+		want           *Detected
+		wantHostSource string
+	}{
+		{"Git.Example.com/example/myrepo", nil, ghes, "GH_REPO"},
+		{"", []Option{OwnerRepo("Git.Example.com/example/myrepo")}, ghes, "factory.Host"},
+		{"github.com/example/myrepo", []Option{OwnerRepo("git.example.com/example/myrepo")}, ghes, "factory.Host"},
+		{"git.example.com/example/myrepo", []Option{Host("github.com"), OwnerRepo("example/myrepo")}, &Detected{
+			Host:            "github.com",
+			RESTEndpoint:    "https://api.github.com",
+			UploadEndpoint:  "https://uploads.github.com",
+			GraphQLEndpoint: "https://api.github.com/graphql",
+			Token:           "GH_TOKEN",
+			TokenSource:     "GH_TOKEN",
+			Owner:           "example",
+			Repo:            "myrepo",
+			AuthMode:        AuthModeToken,
+		}, "factory.Host"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Setenv("GH_REPO", tt.GH_REPO)
+			got, err := Detect(tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := *tt.want
+			want.HostSource = tt.wantHostSource
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("got %#v\nwant %#v", got, &want)
+			}
+		})
+	}
+	if _, err := NewGithubClient(OwnerRepo("/example/myrepo")); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestDerivedEndpoints(t *testing.T) {
+	t.Setenv("GH_HOST", "")
+	t.Setenv("GITHUB_API_URL", "")
+	t.Setenv("GITHUB_GRAPHQL_URL", "")
+	tests := []struct {
+		opts               []Option
+		wantEndpoint       string
+		wantUploadEndpoint string
+		wantV4Endpoint     string
+	}{
+		{nil, "https://api.github.com", "https://uploads.github.com", "https://api.github.com/graphql"},
+		{[]Option{Endpoint("https://api.github.com/")}, "https://api.github.com", "https://uploads.github.com", "https://api.github.com/graphql"},
+		{[]Option{Endpoint("https://git.example.com/api/v3/")}, "https://git.example.com/api/v3", "https://git.example.com/api/uploads", "https://git.example.com/api/graphql"},
+		{[]Option{Endpoint("http://127.0.0.1:8080")}, "http://127.0.0.1:8080", "http://127.0.0.1:8080/api/uploads", "http://127.0.0.1:8080/api/graphql"},
+		{[]Option{Endpoint("https://api.example.ghe.com")}, "https://api.example.ghe.com", "https://uploads.example.ghe.com", "https://api.example.ghe.com/graphql"},
+		{[]Option{Endpoint("http://127.0.0.1:8080"), UploadEndpoint("http://127.0.0.1:8081/"), GraphQLEndpoint("http://127.0.0.1:8082/graphql")}, "http://127.0.0.1:8080", "http://127.0.0.1:8081", "http://127.0.0.1:8082/graphql"},
+		{[]Option{Host("git.example.com"), UploadEndpoint("https://uploads.example.com")}, "https://git.example.com/api/v3", "https://uploads.example.com", "https://git.example.com/api/graphql"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := Detect(append(tt.opts, SkipAuth(true))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.RESTEndpoint != tt.wantEndpoint {
+				t.Errorf("got %v\nwant %v", got.RESTEndpoint, tt.wantEndpoint)
+			}
+			if got.UploadEndpoint != tt.wantUploadEndpoint {
+				t.Errorf("got %v\nwant %v", got.UploadEndpoint, tt.wantUploadEndpoint)
+			}
+			if got.GraphQLEndpoint != tt.wantV4Endpoint {
+				t.Errorf("got %v\nwant %v", got.GraphQLEndpoint, tt.wantV4Endpoint)
+			}
+		})
+	}
+}
+
+func TestParseRemoteURL(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    *gitRemote
+		wantErr bool
+	}{
+		{"https://github.com/k1LoW/go-github-client.git", &gitRemote{host: "github.com", owner: "k1LoW", repo: "go-github-client"}, false},
+		{"https://user@git.example.com:8443/k1LoW/go-github-client", &gitRemote{host: "git.example.com", owner: "k1LoW", repo: "go-github-client"}, false},
+		{"ssh://git@ssh.github.com:443/k1LoW/go-github-client.git", &gitRemote{host: "github.com", owner: "k1LoW", repo: "go-github-client"}, false},
+		{"git@example.ghe.com:k1LoW/go-github-client.git", &gitRemote{host: "example.ghe.com", owner: "k1LoW", repo: "go-github-client"}, false},
+		{"git@github.com:go-github-client.git", nil, true},
+		{"/path/to/repo.git", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRemoteURL(tt.in)
+			if err != nil {
+				if !tt.wantErr {
+					t.Error(err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Error("want error")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitRemote(t *testing.T) {
+	t.Setenv("GH_HOST", "")
+	t.Setenv("GH_REPO", "")
+	t.Setenv("GITHUB_REPOSITORY", "")
+	t.Setenv("GITHUB_REPOSITORY_OWNER", "")
+	t.Setenv("GITHUB_API_URL", "")
+	t.Setenv("GITHUB_GRAPHQL_URL", "")
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config := `[core]
+	bare = false
+[remote "origin"]
+	url = git@git.example.com:k1LoW/go-github-client.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[remote "upstream"]
+	url = https://github.com/octocat/hello-world.git
+[remote "work"]
+	url = git@github-work:octocat/hello-world.git
+`
+	if err := os.WriteFile(filepath.Join(dir, ".git", "config"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "sub")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(sub)
+
+	tests := []struct {
+		opts            []Option
+		enterpriseToken string
+		want            *Detected
+	}{
+		{nil, "", &Detected{
+			Host:            "github.com",
+			RESTEndpoint:    "https://api.github.com",
+			UploadEndpoint:  "https://uploads.github.com",
+			GraphQLEndpoint: "https://api.github.com/graphql",
+		}},
+		{[]Option{GitRemote("")}, "GH_ENTERPRISE_TOKEN", &Detected{
+			Host:            "git.example.com",
+			HostSource:      "git remote origin",
+			RESTEndpoint:    "https://git.example.com/api/v3",
+			UploadEndpoint:  "https://git.example.com/api/uploads",
+			GraphQLEndpoint: "https://git.example.com/api/graphql",
+			Owner:           "k1LoW",
+			Repo:            "go-github-client",
+		}},
+		{[]Option{GitRemote("upstream")}, "", &Detected{
+			Host:            "github.com",
+			HostSource:      "git remote upstream",
+			RESTEndpoint:    "https://api.github.com",
+			UploadEndpoint:  "https://uploads.github.com",
+			GraphQLEndpoint: "https://api.github.com/graphql",
+			Owner:           "octocat",
+			Repo:            "hello-world",
+		}},
+		{[]Option{GitRemote(""), Host("ghes.example.com"), Owner("octocat")}, "", &Detected{
+			Host:            "ghes.example.com",
+			HostSource:      "factory.Host",
+			RESTEndpoint:    "https://ghes.example.com/api/v3",
+			UploadEndpoint:  "https://ghes.example.com/api/uploads",
+			GraphQLEndpoint: "https://ghes.example.com/api/graphql",
+			Owner:           "octocat",
+		}},
+		{[]Option{GitRemote("unknown")}, "", &Detected{
+			Host:            "github.com",
+			RESTEndpoint:    "https://api.github.com",
+			UploadEndpoint:  "https://uploads.github.com",
+			GraphQLEndpoint: "https://api.github.com/graphql",
+		}},
+		{[]Option{GitRemote("")}, "", &Detected{
+			Host:            "github.com",
+			RESTEndpoint:    "https://api.github.com",
+			UploadEndpoint:  "https://uploads.github.com",
+			GraphQLEndpoint: "https://api.github.com/graphql",
+			Owner:           "k1LoW",
+			Repo:            "go-github-client",
+		}},
+		// The SSH config alias is not resolved, so the host falls back to the default host
+		{[]Option{GitRemote("work")}, "", &Detected{
+			Host:            "github.com",
+			RESTEndpoint:    "https://api.github.com",
+			UploadEndpoint:  "https://uploads.github.com",
+			GraphQLEndpoint: "https://api.github.com/graphql",
+			Owner:           "octocat",
+			Repo:            "hello-world",
+		}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Setenv("GH_ENTERPRISE_TOKEN", tt.enterpriseToken)
+			t.Setenv("GITHUB_ENTERPRISE_TOKEN", "")
+			got, err := Detect(append(tt.opts, SkipAuth(true))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := *tt.want
+			want.AuthMode = AuthModeAnonymous
+			if want.HostSource == "" {
+				// The source of the default host depends on the gh config
+				got.HostSource = ""
+			}
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("got %#v\nwant %#v", got, &want)
+			}
+		})
+	}
+}
+
+func TestNewGithubClientFromDetected(t *testing.T) {
+	d := &Detected{
+		RESTEndpoint:   "https://git.example.com/api/v3",
+		UploadEndpoint: "https://git.example.com/api/uploads",
+		Token:          "TOKEN",
+		AuthMode:       AuthModeToken,
+	}
+	c, err := NewGithubClientFromDetected(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.BaseURL.String(), "https://git.example.com/api/v3/"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if got, want := c.UploadURL.String(), "https://git.example.com/api/uploads/"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if _, err := NewGithubClientFromDetected(nil); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestDetectedRedaction(t *testing.T) {
+	d := &Detected{
+		Host:        "github.com",
+		Token:       "ghp_PRIMARY",
+		TokenSource: "GITHUB_TOKEN",
+		AuthMode:    AuthModeToken,
+		tried:       []string{"GH_ENTERPRISE_TOKEN"},
+	}
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("detected", "d", d)
+	outs := map[string]string{
+		"%v":   fmt.Sprintf("%v", d),
+		"%+v":  fmt.Sprintf("%+v", *d),
+		"%#v":  fmt.Sprintf("%#v", *d),
+		"%s":   fmt.Sprintf("%s", d),
+		"slog": buf.String(),
+	}
+	for verb, got := range outs {
+		for _, secret := range []string{"ghp_PRIMARY", "GH_ENTERPRISE_TOKEN"} {
+			if strings.Contains(got, secret) {
+				t.Errorf("%s: %s is printed: %s", verb, secret, got)
+			}
+		}
+		for _, want := range []string{"github.com", "GITHUB_TOKEN", redactedToken} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: got %v\nwant %v in it", verb, got, want)
+			}
+		}
+	}
+	if got := fmt.Sprintf("%#v", *d); !strings.HasPrefix(got, "factory.Detected{") {
+		t.Errorf("got %v\nwant factory.Detected{...}", got)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token ghp_VALID" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message": "Bad credentials"}`))
+			return
+		}
+		w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+		w.Header().Set("X-Accepted-OAuth-Scopes", "")
+		w.Header().Set("github-authentication-token-expiration", "2030-01-02 03:04:05 UTC")
+		_, _ = w.Write([]byte(`{"login": "octocat"}`))
+	})
+	mux.HandleFunc("/installation/repositories", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"total_count": 0, "repositories": []}`))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	tests := []struct {
+		token          string
+		want           *CredentialInfo
+		wantStatusCode int
+	}{
+		{"ghp_VALID", &CredentialInfo{
+			Login:     "octocat",
+			TokenType: TokenTypePersonalAccessToken,
+			Scopes:    []string{"repo", "read:org"},
+			ExpiresAt: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		}, 0},
+		{"ghs_INSTALLATION", &CredentialInfo{
+			TokenType: TokenTypeInstallation,
+		}, 0},
+		{"ghp_INVALID", nil, http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			c, err := NewGithubClient(Endpoint(ts.URL), Token(tt.token), Preflight())
+			if err != nil {
+				var pe *PreflightError
+				if !errors.As(err, &pe) {
+					t.Fatalf("got %T\nwant %T", err, pe)
+				}
+				if pe.StatusCode != tt.wantStatusCode {
+					t.Errorf("got %v\nwant %v", pe.StatusCode, tt.wantStatusCode)
+				}
+				return
+			}
+			if tt.wantStatusCode != 0 {
+				t.Fatal("want error")
+			}
+			got, ok := GetCredentialInfo(c)
+			if !ok {
+				t.Fatal("no credential info")
+			}
+			if got.ExpiresAt.Equal(tt.want.ExpiresAt) {
+				got.ExpiresAt = tt.want.ExpiresAt
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenExpirationWarning(t *testing.T) {
+	expiresAt := time.Now().Add(72 * time.Hour).Truncate(time.Second)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/octocat", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("github-authentication-token-expiration", expiresAt.Format("2006-01-02 15:04:05 -0700"))
+		_, _ = w.Write([]byte(`{"login": "octocat"}`))
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	tests := []struct {
+		within    time.Duration
+		wantCalls int
+	}{
+		{7 * 24 * time.Hour, 1},
+		{24 * time.Hour, 0},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var calls []time.Time
+			c, err := NewGithubClient(Endpoint(ts.URL), Token("github_pat_EXPIRING"), TokenExpirationWarning(tt.within, func(exp time.Time) {
+				calls = append(calls, exp)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				if _, _, err := c.Users.Get(context.Background(), "octocat"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("got %v\nwant %v", len(calls), tt.wantCalls)
+			}
+			for _, got := range calls {
+				if !got.Equal(expiresAt) {
+					t.Errorf("got %v\nwant %v", got, expiresAt)
+				}
+			}
+			info, ok := GetCredentialInfo(c)
+			if !ok {
+				t.Fatal("no credential info")
+			}
+			if !info.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("got %v\nwant %v", info.ExpiresAt, expiresAt)
+			}
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	t.Setenv("GITHUB_APP_ID", "")
+	tests := []struct {
+		opts      []Option
+		wantAuths []string
+		wantErr   bool
+	}{
+		{
+			[]Option{CredentialChain(StaticToken("ghp_EXPIRED"), StaticToken("ghp_VALID")), Failover()},
+			[]string{"token ghp_EXPIRED", "token ghp_VALID", "token ghp_VALID"},
+			false,
+		},
+		{
+			[]Option{Token("ghp_EXPIRED"), CredentialChain(StaticToken("ghp_NOACCESS"), StaticToken("ghp_VALID")), Failover()},
+			[]string{"token ghp_EXPIRED", "token ghp_NOACCESS", "token ghp_NOACCESS"},
+			true,
+		},
+		{
+			[]Option{Token("ghp_EXPIRED"), CredentialChain(StaticToken("ghp_NOACCESS"), StaticToken("ghp_VALID")), FailoverOnForbidden()},
+			[]string{"token ghp_EXPIRED", "token ghp_NOACCESS", "token ghp_VALID", "token ghp_VALID"},
+			false,
+		},
+		{
+			[]Option{CredentialChain(StaticToken("ghp_EXPIRED"), StaticToken("ghp_VALID"))},
+			[]string{"token ghp_EXPIRED", "token ghp_EXPIRED"},
+			true,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var auths []string
+			mux := http.NewServeMux()
+			mux.HandleFunc("/repos/octocat/hello-world/issues", func(w http.ResponseWriter, r *http.Request) {
+				auths = append(auths, r.Header.Get("Authorization"))
+				switch r.Header.Get("Authorization") {
+				case "token ghp_VALID":
+					req := &github.IssueRequest{}
+					if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.GetTitle() != "hello" {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					w.WriteHeader(http.StatusCreated)
+					_, _ = w.Write([]byte(`{"number": 1}`))
+				case "token ghp_NOACCESS":
+					w.WriteHeader(http.StatusForbidden)
+					_, _ = w.Write([]byte(`{"message": "Resource not accessible by integration"}`))
+				default:
+					w.WriteHeader(http.StatusUnauthorized)
+					_, _ = w.Write([]byte(`{"message": "Bad credentials"}`))
+				}
+			})
+			ts := httptest.NewServer(mux)
+			t.Cleanup(ts.Close)
+
+			c, err := NewGithubClient(append(tt.opts, Endpoint(ts.URL))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				_, _, err = c.Issues.Create(context.Background(), "octocat", "hello-world", &github.IssueRequest{Title: github.String("hello")})
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got %v\nwantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(auths, tt.wantAuths) {
+				t.Errorf("got %v\nwant %v", auths, tt.wantAuths)
+			}
+		})
+	}
+
+	t.Run("fallback credentials are resolved on failover", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "token ghp_VALID" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"login": "octocat"}`))
+		}))
+		t.Cleanup(ts.Close)
+		var calls atomic.Int64
+		fallback := TokenSourceFunc(func(_ context.Context, _ string) (string, string, error) {
+			calls.Add(1)
+			return "ghp_VALID", "fallback", nil
+		})
+		opts := []Option{Endpoint(ts.URL), CredentialChain(StaticToken("ghp_EXPIRED"), fallback), Failover()}
+		d, err := Detect(opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := NewGithubClientFromDetected(d, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := calls.Load(); got != 0 {
+			t.Errorf("got %v\nwant %v", got, 0)
+		}
+		for range 2 {
+			if _, _, err := c.Users.Get(context.Background(), "octocat"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := calls.Load(); got != 1 {
+			t.Errorf("got %v\nwant %v", got, 1)
+		}
+	})
+}
+
+func TestAuthorizationHeader(t *testing.T) {
+	tests := []struct {
+		opts        []Option
+		wantHeaders http.Header
+	}{
+		{
+			[]Option{Token("TOKEN")},
+			http.Header{"Authorization": []string{"token TOKEN"}},
+		},
+		{
+			[]Option{Token("TOKEN"), AuthorizationScheme("Bearer")},
+			http.Header{"Authorization": []string{"Bearer TOKEN"}},
+		},
+		{
+			[]Option{Token("TOKEN"), AuthorizationHeader("X-Gateway-Auth"), AuthorizationScheme("Bearer")},
+			http.Header{"Authorization": nil, "X-Gateway-Auth": []string{"Bearer TOKEN"}},
+		},
+		{
+			[]Option{Token("TOKEN"), DefaultHeader("X-Tenant", "example"), DefaultHeader("Authorization", "overridden")},
+			http.Header{"Authorization": []string{"token TOKEN"}, "X-Tenant": []string{"example"}},
+		},
+		{
+			[]Option{SkipAuth(true), DefaultHeader("X-Tenant", "example")},
+			http.Header{"Authorization": nil, "X-Tenant": []string{"example"}},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			var got http.Header
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+				_, _ = w.Write([]byte(`{"login": "octocat"}`))
+			}))
+			t.Cleanup(ts.Close)
+			c, err := NewGithubClient(append(tt.opts, Endpoint(ts.URL))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := c.Users.Get(context.Background(), "octocat"); err != nil {
+				t.Fatal(err)
+			}
+			for k, want := range tt.wantHeaders {
+				if !reflect.DeepEqual(got.Values(k), want) {
+					t.Errorf("%s: got %v\nwant %v", k, got.Values(k), want)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorizedHosts(t *testing.T) {
+	var got []string
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(storage.Close)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, storage.URL+"/archive", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(api.Close)
+	storageHost := strings.TrimPrefix(storage.URL, "http://")
+
+	tests := []struct {
+		opts []Option
+		path string
+		want []string
+	}{
+		{nil, "user", []string{"token TOKEN"}},
+		{nil, "redirect", []string{"token TOKEN", ""}},
+		{nil, storage.URL + "/archive", []string{""}},
+		{[]Option{AuthorizedHosts(storageHost)}, "redirect", []string{"token TOKEN", "token TOKEN"}},
+		{[]Option{AuthorizedHosts("127.0.0.1")}, storage.URL + "/archive", []string{"token TOKEN"}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got = nil
+			c, err := NewGithubClient(append(tt.opts, Endpoint(api.URL), Token("TOKEN"))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := c.BaseURL.Parse(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, u.String(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := doRequest(c, req); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q\nwant %q", got, tt.want)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Error("the request is modified")
+			}
+		})
+	}
+}
+
+// doRequest sends req using c. Since go-github v86, Do takes the context of req instead of the argument.
+func doRequest(c *github.Client, req *http.Request) error {
+	switch d := any(c).(type) {
+	case interface {
+		Do(context.Context, *http.Request, any) (*github.Response, error)
+	}:
+		_, err := d.Do(req.Context(), req, nil)
+		return err
+	case interface {
+		Do(*http.Request, any) (*github.Response, error)
+	}:
+		_, err := d.Do(req, nil)
+		return err
+	default:
+		return errors.New("unsupported github.Client")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var gotHost, gotAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.URL.Host
+		gotAuth = r.Header.Get("Authorization")
+		_, _ = w.Write([]byte(`{"login": "octocat"}`))
+	}))
+	t.Cleanup(proxy.Close)
+	c, err := NewGithubClient(Endpoint("http://ghes.example.test/api/v3"), Token("TOKEN"), Proxy(proxy.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _, err := c.Users.Get(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u.GetLogin(), "octocat"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if want := "ghes.example.test"; gotHost != want {
+		t.Errorf("got %v\nwant %v", gotHost, want)
+	}
+	if want := "token TOKEN"; gotAuth != want {
+		t.Errorf("got %v\nwant %v", gotAuth, want)
+	}
+
+	if _, err := NewGithubClient(Proxy("proxy.example.com:8080")); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestTLS(t *testing.T) {
+	t.Setenv("GITHUB_CA_CERT_FILE", "")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"login": "octocat"}`))
+	}))
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts                []Option
+		GITHUB_CA_CERT_FILE string
+		wantErr             bool
+	}{
+		{nil, "", true},
+		{[]Option{RootCAs(caPEM)}, "", true},
+		{[]Option{ClientCertificate(certPEM, keyPEM)}, "", true},
+		{[]Option{RootCAs(caPEM), ClientCertificate(certPEM, keyPEM)}, "", false},
+		{[]Option{CACertFile(caFile), ClientCertificate(certPEM, keyPEM)}, "", false},
+		{[]Option{ClientCertificate(certPEM, keyPEM)}, caFile, false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Setenv("GITHUB_CA_CERT_FILE", tt.GITHUB_CA_CERT_FILE)
+			c, err := NewGithubClient(append(tt.opts, Endpoint(ts.URL), Token("TOKEN"))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, _, err := c.Users.Get(context.Background(), "")
+			if err != nil {
+				if !tt.wantErr {
+					t.Error(err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Error("want error")
+			}
+			if got, want := u.GetLogin(), "octocat"; got != want {
+				t.Errorf("got %v\nwant %v", got, want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := NewGithubClient(RootCAs([]byte("invalid"))); err == nil {
+			t.Error("want error")
+		}
+		if _, err := NewGithubClient(CACertFile(filepath.Join(t.TempDir(), "notfound.pem"))); err == nil {
+			t.Error("want error")
+		}
+		if _, err := NewGithubClient(ClientCertificate(certPEM, []byte("invalid"))); err == nil {
+			t.Error("want error")
+		}
+		t.Setenv("GITHUB_CA_CERT_FILE", filepath.Join(t.TempDir(), "notfound.pem"))
+		if _, err := NewGithubClient(); err == nil {
+			t.Error("want error")
+		}
+	})
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed atomic.Bool
+}
+
+func (b *closeRecorder) Close() error {
+	b.closed.Store(true)
+	return nil
+}
+
+func TestRoundTripperClosesBodyOnError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(ts.Close)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newConfig()
+	atr, err := newAppsTransport(c, http.DefaultTransport, 1, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		rt   func(rt *roundTripper)
+	}{
+		{"installation ID of context without GitHub App", WithInstallationID(context.Background(), 1), func(rt *roundTripper) {
+			rt.fromContext = true
+		}},
+		{"installation token", context.Background(), func(rt *roundTripper) {
+			rt.itr = ghinstallation.NewFromAppsTransport(atr, 1)
+		}},
+		{"user access token", context.Background(), func(rt *roundTripper) {
+			c := newConfig()
+			c.UserAccessToken = &UserAccessToken{AccessToken: "ghu_EXPIRED", ExpiresAt: time.Now().Add(-time.Hour)}
+			rt.refresher = newUserTokenRefresher(c, ts.URL)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newRoundTripper(c, http.DefaultTransport, ts.URL)
+			tt.rt(rt)
+			body := &closeRecorder{Reader: strings.NewReader(`{"title": "hello"}`)}
+			req, err := http.NewRequestWithContext(tt.ctx, http.MethodPost, ts.URL+"/repos/octocat/hello-world/issues", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rt.RoundTrip(req); err == nil {
+				t.Fatal("want error")
+			}
+			if !body.closed.Load() {
+				t.Error("the request body is not closed")
+			}
+		})
+	}
+}
+
+func TestContextCredentials(t *testing.T) {
+	t.Setenv("GITHUB_APP_ID", "")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			w.Header().Set(tokenExpirationHeader, time.Now().Add(time.Hour).UTC().Format("2006-01-02 15:04:05 UTC"))
+		}
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"login": %q}`, r.Header.Get("Authorization"))))
+	}))
+	t.Cleanup(ts.Close)
+	c, err := NewGithubClient(Endpoint(ts.URL), Token("SERVICE_TOKEN"), ContextCredentials(), TokenExpirationWarning(2*time.Hour, func(expiresAt time.Time) {
+		t.Errorf("the expiration of the token of the context is observed: %v", expiresAt)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ctx     context.Context
+		want    string
+		wantErr bool
+	}{
+		{WithToken(context.Background(), "TENANT_A"), "token TENANT_A", false},
+		{WithToken(context.Background(), "TENANT_B"), "token TENANT_B", false},
+		{context.Background(), "", false},
+		{WithInstallationID(context.Background(), 1), "", true},
+	}
+	t.Run("tenants", func(t *testing.T) {
+		for i, tt := range tests {
+			t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+				t.Parallel()
+				u, _, err := c.Users.Get(tt.ctx, "octocat")
+				if err != nil {
+					if !tt.wantErr {
+						t.Error(err)
+					}
+					return
+				}
+				if tt.wantErr {
+					t.Fatal("want error")
+				}
+				if got := u.GetLogin(); got != tt.want {
+					t.Errorf("got %v\nwant %v", got, tt.want)
+				}
+			})
+		}
+	})
+	info, ok := GetCredentialInfo(c)
+	if !ok {
+		t.Fatal("no credential info")
+	}
+	if !info.ExpiresAt.IsZero() {
+		t.Errorf("got %v\nwant zero", info.ExpiresAt)
+	}
+}
+
+func TestNewTenantClient(t *testing.T) {
+	t.Setenv("GITHUB_APP_ID", "")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remaining := "59"
+		if r.Header.Get("Authorization") == "token TENANT_A" {
+			remaining = "0"
+		}
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", remaining)
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		_, _ = w.Write([]byte(`{"login": "octocat"}`))
+	}))
+	t.Cleanup(ts.Close)
+	c, err := NewGithubClient(Endpoint(ts.URL), ContextCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantA := WithToken(context.Background(), "TENANT_A")
+	tenantB := WithToken(context.Background(), "TENANT_B")
+
+	t.Run("shared client", func(t *testing.T) {
+		if _, _, err := c.Users.Get(tenantA, ""); err != nil {
+			t.Fatal(err)
+		}
+		var rerr *github.RateLimitError
+		if _, _, err := c.Users.Get(tenantB, ""); !errors.As(err, &rerr) {
+			t.Errorf("got %v\nwant *github.RateLimitError", err)
+		}
+	})
+
+	t.Run("tenant clients", func(t *testing.T) {
+		ca, err := NewTenantClient(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cb, err := NewTenantClient(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := ca.BaseURL.String(), c.BaseURL.String(); got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if _, _, err := ca.Users.Get(tenantA, ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := cb.Users.Get(tenantB, ""); err != nil {
+			t.Error(err)
+		}
+		var rerr *github.RateLimitError
+		if _, _, err := ca.Users.Get(tenantA, ""); !errors.As(err, &rerr) {
+			t.Errorf("got %v\nwant *github.RateLimitError", err)
+		}
+	})
+
+	t.Run("not ContextCredentials", func(t *testing.T) {
+		c, err := NewGithubClient(Endpoint(ts.URL), Token("TOKEN"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewTenantClient(c); err == nil {
+			t.Error("want error")
+		}
+	})
+}
+
 func testdataDir(t *testing.T) string {
 	t.Helper()
 	wd, err := os.Getwd()
@@ -0,0 +1,151 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v36/github"
+)
+
+// InstallationPool is a pool of clients authenticated as installations of a GitHub App.
+// Installation IDs and installation transports are resolved lazily per owner/repository and cached.
+// All clients, including the client of the GitHub App minting installation tokens, share the underlying transport (connection pool).
+type InstallationPool struct {
+	c          *Config
+	ep         string
+	v3upload   string
+	atr        *ghinstallation.AppsTransport
+	base       *http.Transport
+	appClient  *github.Client
+	mu         sync.RWMutex
+	ids        map[string]int64
+	transports map[int64]*ghinstallation.Transport
+}
+
+// NewInstallationPool returns an InstallationPool with environment variable resolution.
+func NewInstallationPool(opts ...Option) (*InstallationPool, error) {
+	c, err := newConfigWithOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := detectHostAndEndpoints(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return newInstallationPool(c, d)
+}
+
+func newInstallationPool(c *Config, d *Detected) (*InstallationPool, error) {
+	base := newTransport(c)
+	appClient, atr, err := newGithubAppClient(c, base, d.RESTEndpoint, d.UploadEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InstallationPool{
+		c:          c,
+		ep:         d.RESTEndpoint,
+		v3upload:   d.UploadEndpoint,
+		atr:        atr,
+		base:       base,
+		appClient:  appClient,
+		ids:        map[string]int64{},
+		transports: map[int64]*ghinstallation.Transport{},
+	}, nil
+}
+
+// ForOwner returns github.com/google/go-github/v36/github.Client authenticated as the installation for the owner.
+func (p *InstallationPool) ForOwner(ctx context.Context, owner string) (*github.Client, error) {
+	if owner == "" {
+		return nil, errors.New("owner is empty")
+	}
+	installationID, err := p.installationID(ctx, owner, "")
+	if err != nil {
+		return nil, err
+	}
+	return p.ForInstallation(installationID)
+}
+
+// ForRepo returns github.com/google/go-github/v36/github.Client authenticated as the installation for the repository (owner/repo).
+func (p *InstallationPool) ForRepo(ctx context.Context, ownerrepo string) (*github.Client, error) {
+	splitted := strings.Split(ownerrepo, "/")
+	if len(splitted) != 2 || splitted[0] == "" || splitted[1] == "" {
+		return nil, fmt.Errorf("invalid owner/repo format: %s", ownerrepo)
+	}
+	installationID, err := p.installationID(ctx, splitted[0], splitted[1])
+	if err != nil {
+		return nil, err
+	}
+	return p.ForInstallation(installationID)
+}
+
+// ForInstallation returns github.com/google/go-github/v36/github.Client authenticated as the installation.
+func (p *InstallationPool) ForInstallation(installationID int64) (*github.Client, error) {
+	itr, err := p.transport(installationID)
+	if err != nil {
+		return nil, err
+	}
+	rt := newRoundTripper(p.c, p.base, p.ep, p.v3upload)
+	rt.itr = itr
+	v3c := github.NewClient(&http.Client{
+		Timeout:   p.c.Timeout,
+		Transport: rt,
+	})
+	if err := setEndpoints(v3c, p.ep, p.v3upload); err != nil {
+		return nil, err
+	}
+	return v3c, nil
+}
+
+// AppClient returns github.com/google/go-github/v36/github.Client authenticated as the GitHub App itself (JWT).
+func (p *InstallationPool) AppClient() *github.Client {
+	return p.appClient
+}
+
+func (p *InstallationPool) installationID(ctx context.Context, owner, repo string) (int64, error) {
+	key := owner
+	if repo != "" {
+		key = fmt.Sprintf("%s/%s", owner, repo)
+	}
+	p.mu.RLock()
+	id, ok := p.ids[key]
+	p.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+	id, err := findInstallationID(ctx, p.appClient, owner, repo)
+	if err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	p.ids[key] = id
+	p.mu.Unlock()
+	return id, nil
+}
+
+func (p *InstallationPool) transport(installationID int64) (*ghinstallation.Transport, error) {
+	p.mu.RLock()
+	itr, ok := p.transports[installationID]
+	p.mu.RUnlock()
+	if ok {
+		return itr, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if itr, ok := p.transports[installationID]; ok {
+		return itr, nil
+	}
+	itr = ghinstallation.NewFromAppsTransport(p.atr, installationID)
+	if err := setInstallationTokenOptions(itr, p.c); err != nil {
+		return nil, err
+	}
+	p.transports[installationID] = itr
+	return itr, nil
+}
@@ -2,8 +2,13 @@ package factory
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -21,17 +26,54 @@ const defaultHost = "github.com"
 const defaultV3Endpoint = "https://api.github.com"
 const defaultUploadEndpoint = "https://uploads.github.com"
 const defaultV4Endpoint = "https://api.github.com/graphql"
+const tenancyHostSuffix = ".ghe.com"
 
 type Config struct {
-	Token               string
-	Endpoint            string
-	Owner               string
-	Repo                string
-	DialTimeout         time.Duration
-	TLSHandshakeTimeout time.Duration
-	Timeout             time.Duration
-	HTTPClient          *http.Client
-	SkipAuth            bool
+	Token                    string
+	Host                     string
+	Endpoint                 string
+	UploadEndpoint           string
+	GraphQLEndpoint          string
+	GitRemote                string
+	Owner                    string
+	Repo                     string
+	DialTimeout              time.Duration
+	TLSHandshakeTimeout      time.Duration
+	Timeout                  time.Duration
+	Proxy                    *url.URL
+	RootCAs                  *x509.CertPool
+	ClientCertificates       []tls.Certificate
+	HTTPClient               *http.Client
+	SkipAuth                 bool
+	AppID                    int64
+	InstallationID           int64
+	PrivateKey               []byte
+	InstallationRepositories []string
+	InstallationPermissions  *github.InstallationPermissions
+	OIDCBrokerURL            string
+	OIDCAudience             string
+	DeviceFlowClientID       string
+	DeviceFlowScopes         []string
+	DeviceFlowTokenFile      string
+	DeviceFlowOutput         io.Writer
+	DeviceFlowInterval       time.Duration
+	TokenSources             []TokenSource
+	Preflight                bool
+	TokenExpirationWarning   time.Duration
+	OnTokenExpirationWarning func(expiresAt time.Time)
+	Failover                 bool
+	FailoverOnForbidden      bool
+	AuthorizationHeader      string
+	AuthorizationScheme      string
+	DefaultHeaders           http.Header
+	AuthorizedHosts          []string
+	ContextCredentials       bool
+	AppSigner                crypto.Signer
+	UserAccessToken          *UserAccessToken
+	UserTokenClientID        string
+	UserTokenClientSecret    string
+	OnUserTokenRefresh       func(t UserAccessToken)
+	AllowAnonymous           bool
 }
 
 type Option func(*Config) error
@@ -54,6 +96,37 @@ func Endpoint(t string) Option {
 	}
 }
 
+// Host sets the host ( e.g. github.com, git.example.com ) instead of the host detected by environment variables and the gh config.
+// The token for the host is resolved and the endpoints are derived from the host.
+func Host(host string) Option {
+	return func(c *Config) error {
+		if host != "" {
+			c.Host = strings.ToLower(host)
+		}
+		return nil
+	}
+}
+
+// UploadEndpoint sets the upload endpoint instead of the endpoint derived from the host or Endpoint.
+func UploadEndpoint(ep string) Option {
+	return func(c *Config) error {
+		if ep != "" {
+			c.UploadEndpoint = ep
+		}
+		return nil
+	}
+}
+
+// GraphQLEndpoint sets the GraphQL API endpoint instead of the endpoint derived from the host or Endpoint.
+func GraphQLEndpoint(ep string) Option {
+	return func(c *Config) error {
+		if ep != "" {
+			c.GraphQLEndpoint = ep
+		}
+		return nil
+	}
+}
+
 func DialTimeout(to time.Duration) Option {
 	return func(c *Config) error {
 		if to > 0 {
@@ -81,6 +154,25 @@ func Timeout(to time.Duration) Option {
 	}
 }
 
+// Proxy sets the URL of the proxy used by the transports built by the factory.
+// It takes precedence over env HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func Proxy(proxyURL string) Option {
+	return func(c *Config) error {
+		if proxyURL == "" {
+			return nil
+		}
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL: %s", proxyURL)
+		}
+		c.Proxy = u
+		return nil
+	}
+}
+
 func HTTPClient(httpClient *http.Client) Option {
 	return func(c *Config) error {
 		if httpClient != nil {
@@ -97,6 +189,117 @@ func SkipAuth(enable bool) Option {
 	}
 }
 
+// AppID sets the GitHub App ID. It takes precedence over env GITHUB_APP_ID.
+// Setting it also makes the GitHub App take precedence over the tokens detected from environment variables,
+// the gh config and CredentialChain ( Token, RefreshableUserToken and OIDCTokenExchange still take precedence ).
+func AppID(id int64) Option {
+	return func(c *Config) error {
+		if id > 0 {
+			c.AppID = id
+		}
+		return nil
+	}
+}
+
+// InstallationID sets the GitHub App installation ID. It takes precedence over env GITHUB_APP_INSTALLATION_ID.
+func InstallationID(id int64) Option {
+	return func(c *Config) error {
+		if id > 0 {
+			c.InstallationID = id
+		}
+		return nil
+	}
+}
+
+// PrivateKey sets the GitHub App private key (PEM). It takes precedence over env GITHUB_APP_PRIVATE_KEY.
+// Unless AppID is set, the tokens detected from environment variables and the gh config take precedence over the GitHub App.
+func PrivateKey(key []byte) Option {
+	return func(c *Config) error {
+		if len(key) > 0 {
+			c.PrivateKey = key
+		}
+		return nil
+	}
+}
+
+// PrivateKeyFile sets the GitHub App private key (PEM) read from path. It takes precedence over env GITHUB_APP_PRIVATE_KEY.
+// Unless AppID is set, the tokens detected from environment variables and the gh config take precedence over the GitHub App.
+func PrivateKeyFile(path string) Option {
+	return func(c *Config) error {
+		if path == "" {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		c.PrivateKey = b
+		return nil
+	}
+}
+
+// InstallationRepositories restricts the access of GitHub App installation tokens to the repositories (names).
+func InstallationRepositories(repos ...string) Option {
+	return func(c *Config) error {
+		c.InstallationRepositories = append(c.InstallationRepositories, repos...)
+		return nil
+	}
+}
+
+// InstallationPermissions restricts the permissions of GitHub App installation tokens.
+func InstallationPermissions(perms *github.InstallationPermissions) Option {
+	return func(c *Config) error {
+		if perms != nil {
+			c.InstallationPermissions = perms
+		}
+		return nil
+	}
+}
+
+// AuthorizationHeader sets the name of the header to send credentials. The default is Authorization.
+func AuthorizationHeader(name string) Option {
+	return func(c *Config) error {
+		if name != "" {
+			c.AuthorizationHeader = name
+		}
+		return nil
+	}
+}
+
+// AuthorizationScheme sets the scheme of credentials such as Bearer. The default is token.
+// The JWT of the GitHub App is always sent using Bearer.
+func AuthorizationScheme(scheme string) Option {
+	return func(c *Config) error {
+		if scheme != "" {
+			c.AuthorizationScheme = scheme
+		}
+		return nil
+	}
+}
+
+// DefaultHeader adds the header sent with every request. It does not override the header set on the request.
+func DefaultHeader(key, value string) Option {
+	return func(c *Config) error {
+		if key == "" {
+			return nil
+		}
+		if c.DefaultHeaders == nil {
+			c.DefaultHeaders = http.Header{}
+		}
+		c.DefaultHeaders.Add(key, value)
+		return nil
+	}
+}
+
+// AuthorizedHosts adds the hosts ( host or host:port ) to send credentials to.
+// By default, credentials are sent only to the hosts of the REST API, upload and GraphQL API endpoints.
+func AuthorizedHosts(hosts ...string) Option {
+	return func(c *Config) error {
+		c.AuthorizedHosts = append(c.AuthorizedHosts, hosts...)
+		return nil
+	}
+}
+
 func Owner(owner string) Option {
 	return func(c *Config) error {
 		c.Owner = owner
@@ -104,84 +307,136 @@ func Owner(owner string) Option {
 	}
 }
 
+// OwnerRepo sets the owner/repo. If it is host/owner/repo, the host is also set as Host does.
 func OwnerRepo(ownerrepo string) Option {
 	return func(c *Config) error {
 		splitted := strings.Split(ownerrepo, "/")
-		if len(splitted) != 2 {
+		switch len(splitted) {
+		case 2:
+			c.Owner = splitted[0]
+			c.Repo = splitted[1]
+		case 3:
+			if splitted[0] == "" {
+				return errors.New("invalid host/owner/repo format")
+			}
+			c.Host = strings.ToLower(splitted[0])
+			c.Owner = splitted[1]
+			c.Repo = splitted[2]
+		default:
 			return errors.New("invalid owner/repo format")
 		}
-		c.Owner = splitted[0]
-		c.Repo = splitted[1]
 		return nil
 	}
 }
 
 // NewGithubClient returns github.com/google/go-github/v37/github.Client with environment variable resolution.
 func NewGithubClient(opts ...Option) (*github.Client, error) {
-	c := &Config{
-		Token:               "",
-		DialTimeout:         5 * time.Second,
-		TLSHandshakeTimeout: 5 * time.Second,
-		Timeout:             30 * time.Second,
+	c, err := newConfigWithOptions(opts...)
+	if err != nil {
+		return nil, err
 	}
-	for _, o := range opts {
-		if err := o(c); err != nil {
-			return nil, err
-		}
+
+	d, err := detect(context.Background(), c)
+	if err != nil {
+		return nil, err
 	}
 
-	token, v3ep, v3upload, _ := GetTokenAndEndpoints()
+	return newGithubClientFromDetected(c, d)
+}
 
-	if c.Token == "" {
-		c.Token = token
+// NewGithubAppClient returns github.com/google/go-github/v37/github.Client authenticated as the GitHub App itself (JWT).
+// The client can call endpoints for GitHub Apps such as Apps.ListInstallations and Apps.CreateInstallationToken.
+func NewGithubAppClient(opts ...Option) (*github.Client, error) {
+	c, err := newConfigWithOptions(opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	if c.SkipAuth {
-		c.Token = ""
+	d, err := detectHostAndEndpoints(c)
+	if err != nil {
+		return nil, err
 	}
 
-	ep := c.Endpoint
-	if ep == "" {
-		ep = v3ep
+	v3c, _, err := newGithubAppClient(c, newTransport(c), d.RESTEndpoint, d.UploadEndpoint)
+	if err != nil {
+		return nil, err
 	}
 
-	if !c.SkipAuth && c.Token == "" && c.HTTPClient == nil {
-		hc, err := newHTTPClientUsingGitHubApp(c, ep)
-		if err != nil {
-			return nil, errors.New("no credentials found")
-		}
-		c.HTTPClient = hc
-	}
+	return v3c, nil
+}
 
-	v3c := github.NewClient(httpClient(c))
-	baseEndpoint, err := url.Parse(ep)
+// newGithubAppClient returns the client authenticated as the GitHub App itself and its transport sending requests using base.
+func newGithubAppClient(c *Config, base http.RoundTripper, ep, v3upload string) (*github.Client, *ghinstallation.AppsTransport, error) {
+	appID, _, privateKey, err := appCredentials(c)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if !strings.HasSuffix(baseEndpoint.Path, "/") {
-		baseEndpoint.Path += "/"
+	atr, err := newAppsTransport(c, base, appID, privateKey, ep)
+	if err != nil {
+		return nil, nil, err
+	}
+	v3c := github.NewClient(&http.Client{
+		Timeout:   c.Timeout,
+		Transport: atr,
+	})
+	if err := setEndpoints(v3c, ep, v3upload); err != nil {
+		return nil, nil, err
 	}
-	v3c.BaseURL = baseEndpoint
+	return v3c, atr, nil
+}
 
-	if c.Endpoint != "" {
-		if !strings.Contains(baseEndpoint.Host, defaultHost) {
-			v3c.UploadURL, err = url.Parse(fmt.Sprintf("https://%s/api/uploads/", baseEndpoint.Host))
-			if err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		uploadEndpoint, err := url.Parse(v3upload)
-		if err != nil {
+func newConfig() *Config {
+	return &Config{
+		Token:               "",
+		DialTimeout:         5 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+		Timeout:             30 * time.Second,
+		DeviceFlowInterval:  deviceFlowDefaultInterval,
+		AuthorizationHeader: "Authorization",
+		AuthorizationScheme: "token",
+	}
+}
+
+// newConfigWithOptions returns Config with the options applied. Env GITHUB_CA_CERT_FILE is used unless the CA certificates are set by the options.
+func newConfigWithOptions(opts ...Option) (*Config, error) {
+	c := newConfig()
+	for _, o := range opts {
+		if err := o(c); err != nil {
 			return nil, err
 		}
-		if !strings.HasSuffix(uploadEndpoint.Path, "/") {
-			uploadEndpoint.Path += "/"
+	}
+	if c.RootCAs == nil {
+		if err := CACertFile(os.Getenv("GITHUB_CA_CERT_FILE"))(c); err != nil {
+			return nil, err
 		}
-		v3c.UploadURL = uploadEndpoint
 	}
+	return c, nil
+}
 
-	return v3c, nil
+// setEndpoints sets BaseURL and UploadURL of the client.
+func setEndpoints(v3c *github.Client, ep, v3upload string) error {
+	var err error
+	v3c.BaseURL, err = parseEndpoint(ep)
+	if err != nil {
+		return err
+	}
+	v3c.UploadURL, err = parseEndpoint(v3upload)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseEndpoint parses the endpoint URL and adds a trailing slash.
+func parseEndpoint(ep string) (*url.URL, error) {
+	u, err := url.Parse(ep)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u, nil
 }
 
 // GetTokenAndEndpoints returns token and endpoints. The endpoints to be generated are URLs without a trailing slash.
@@ -191,114 +446,272 @@ func GetTokenAndEndpoints() (token string, v3ep string, v3upload string, v4ep st
 }
 
 // GetAllDetected returns token, endpoints, host and sources. The endpoints to be generated are URLs without a trailing slash.
+//
+// Deprecated: Use Detect instead.
 func GetAllDetected() (token, v3ep, v3upload, v4ep, host, hostSource, tokenSource string) {
-	host, hostSource = auth.DefaultHost()
+	host, hostSource = detectHost(newConfig())
 	token, tokenSource = auth.TokenForHost(host)
-	v3ep = defaultV3Endpoint
-	v3upload = defaultUploadEndpoint
-	v4ep = defaultV4Endpoint
-	if host != defaultHost {
-		// GitHub Enterprise Server
-		v3ep = fmt.Sprintf("https://%s/api/v3", host)
-		v3upload = fmt.Sprintf("https://%s/api/uploads", host)
-		v4ep = fmt.Sprintf("https://%s/api/graphql", host)
-	} else {
-		// GitHub Actions or GitHub.com
-		if os.Getenv("GITHUB_API_URL") != "" {
-			v3ep = os.Getenv("GITHUB_API_URL")
-			ep, err := url.Parse(v3ep)
-			if err == nil && ep.Host != "" {
-				if !strings.Contains(ep.Host, defaultHost) {
-					v3upload = fmt.Sprintf("https://%s/api/uploads", ep.Host)
-				}
-			}
-		}
-		if os.Getenv("GITHUB_GRAPHQL_URL") != "" {
-			v4ep = os.Getenv("GITHUB_GRAPHQL_URL")
-		}
-	}
+	v3ep, v3upload, v4ep = detectEndpoints(host)
 
 	return token, v3ep, v3upload, v4ep, host, hostSource, tokenSource
 }
 
 type roundTripper struct {
-	transport   *http.Transport
+	transport   http.RoundTripper
 	accessToken string
+	// itr is the transport to get the installation token of the GitHub App. If set, accessToken is not used.
+	itr        *ghinstallation.Transport
+	authHeader string
+	authScheme string
+	headers    http.Header
+	// hosts is the hosts to send credentials and the headers to ( host:port or host ).
+	hosts []string
+	// fromContext enables to use the credentials of the context of the request instead of accessToken and itr.
+	fromContext bool
+	// pool is the pool of installation transports used for the installation ID of the context.
+	pool *InstallationPool
+	// refresher refreshes the user access token of the GitHub App. If set, accessToken is not used.
+	refresher *userTokenRefresher
+	state     *clientState
 }
 
-func (rt roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
-	if rt.accessToken != "" {
-		r.Header.Set("Authorization", fmt.Sprintf("token %s", rt.accessToken))
+// newRoundTripper returns roundTripper without credentials that sends the headers of Config to the hosts of the endpoints.
+func newRoundTripper(c *Config, transport http.RoundTripper, endpoints ...string) *roundTripper {
+	return &roundTripper{
+		transport:  transport,
+		authHeader: c.AuthorizationHeader,
+		authScheme: c.AuthorizationScheme,
+		headers:    c.DefaultHeaders,
+		hosts:      authorizedHosts(c, endpoints...),
 	}
-	return rt.transport.RoundTrip(r)
 }
 
-func newHTTPClientUsingGitHubApp(c *Config, ep string) (*http.Client, error) {
-	envAppID := os.Getenv("GITHUB_APP_ID")
-	envInstallaitonID := os.Getenv("GITHUB_APP_INSTALLATION_ID")
-	envPrivateKey := os.Getenv("GITHUB_APP_PRIVATE_KEY")
-	if envAppID == "" || envPrivateKey == "" {
-		return nil, errors.New("not enough credentials to authenticate using GitHub app")
+func (rt *roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	authHeader := rt.authHeaderName()
+	r = r.Clone(r.Context())
+	if !rt.authorized(r.URL) {
+		// Never send credentials to foreign hosts ( e.g. redirects to storage hosts )
+		r.Header.Del("Authorization")
+		r.Header.Del(authHeader)
+		return rt.roundTrip(r)
+	}
+	token := rt.accessToken
+	var err error
+	switch {
+	case rt.fromContext:
+		token, err = rt.tokenFromContext(r.Context())
+	case rt.itr != nil:
+		token, err = rt.itr.Token(r.Context())
+	case rt.refresher != nil:
+		token, err = rt.refresher.token(r.Context())
 	}
-	appID, err := strconv.ParseInt(envAppID, 10, 64)
 	if err != nil {
+		// RoundTrip must close the request body even on errors
+		if r.Body != nil {
+			_ = r.Body.Close()
+		}
 		return nil, err
 	}
-	privateKey := []byte(repairKey(envPrivateKey))
-	var installationID int64
-	if envInstallaitonID == "" {
-		installationID, err = detectInstallationID(c, appID, privateKey, ep)
-		if err != nil {
-			return nil, err
+	for k, v := range rt.headers {
+		if _, ok := r.Header[k]; !ok {
+			r.Header[k] = append([]string(nil), v...)
 		}
-	} else {
-		installationID, err = strconv.ParseInt(envInstallaitonID, 10, 64)
+	}
+	switch {
+	case token != "":
+		rt.setToken(r, token)
+	case authHeader != "Authorization" && r.Header.Get("Authorization") != "":
+		// Credentials set by the GitHub App transport ( JWT )
+		r.Header.Set(authHeader, r.Header.Get("Authorization"))
+		r.Header.Del("Authorization")
+	}
+	res, err := rt.roundTrip(r)
+	if err != nil || res.StatusCode != http.StatusUnauthorized || rt.refresher == nil || rt.fromContext {
+		return res, err
+	}
+	// The token may expire earlier than expected, so refresh it and retry
+	return rt.retryWithRefreshedToken(r, res, token)
+}
+
+func (rt *roundTripper) authHeaderName() string {
+	if rt.authHeader == "" {
+		return "Authorization"
+	}
+	return rt.authHeader
+}
+
+func (rt *roundTripper) setToken(r *http.Request, token string) {
+	authScheme := rt.authScheme
+	if authScheme == "" {
+		authScheme = "token"
+	}
+	r.Header.Set(rt.authHeaderName(), fmt.Sprintf("%s %s", authScheme, token))
+}
+
+func (rt *roundTripper) roundTrip(r *http.Request) (*http.Response, error) {
+	res, err := rt.transport.RoundTrip(r)
+	if err == nil && rt.state != nil {
+		rt.state.observe(res)
+	}
+	return res, err
+}
+
+// authorized reports whether the credentials can be sent to the host of the URL.
+func (rt *roundTripper) authorized(u *url.URL) bool {
+	h := canonicalHost(u)
+	for _, host := range rt.hosts {
+		if strings.EqualFold(host, h) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizedHosts returns the hosts of the endpoints and Config.AuthorizedHosts.
+func authorizedHosts(c *Config, endpoints ...string) []string {
+	var hosts []string
+	for _, ep := range endpoints {
+		u, err := url.Parse(ep)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		hosts = append(hosts, canonicalHost(u))
+	}
+	return append(hosts, c.AuthorizedHosts...)
+}
+
+// canonicalHost returns host:port of the URL. The port is the default port of the scheme if not specified.
+func canonicalHost(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// newHTTPClientUsingGitHubApp returns http.Client authenticated as the installation of the GitHub App.
+// The error is *AppAuthError.
+func newHTTPClientUsingGitHubApp(c *Config, ep string) (*http.Client, error) {
+	appID, installationID, privateKey, err := appCredentials(c)
+	if err != nil {
+		return nil, &AppAuthError{AppID: appID, Err: err}
+	}
+	if installationID == 0 {
+		installationID, err = detectInstallationID(c, appID, privateKey, ep)
 		if err != nil {
-			return nil, err
+			return nil, &AppAuthError{AppID: appID, Err: err}
 		}
 	}
-	hc := httpClient(c)
-	itr, err := ghinstallation.New(http.DefaultTransport, appID, installationID, privateKey)
+	hc := httpClient(c, ep)
+	atr, err := newAppsTransport(c, newTransport(c), appID, privateKey, ep)
 	if err != nil {
-		return nil, err
+		return nil, &AppAuthError{AppID: appID, InstallationID: installationID, Err: err}
+	}
+	itr := ghinstallation.NewFromAppsTransport(atr, installationID)
+	if err := setInstallationTokenOptions(itr, c); err != nil {
+		return nil, &AppAuthError{AppID: appID, InstallationID: installationID, Err: err}
+	}
+	if rt, ok := hc.Transport.(*roundTripper); ok {
+		rt.accessToken = ""
+		rt.itr = itr
+		return hc, nil
 	}
-	itr.BaseURL = ep
 	hc.Transport = itr
 	return hc, nil
 }
 
+// setInstallationTokenOptions sets the options to restrict the access of installation tokens.
+func setInstallationTokenOptions(itr *ghinstallation.Transport, c *Config) error {
+	if len(c.InstallationRepositories) == 0 && c.InstallationPermissions == nil {
+		return nil
+	}
+	b, err := json.Marshal(struct {
+		Repositories []string                        `json:"repositories,omitempty"`
+		Permissions  *github.InstallationPermissions `json:"permissions,omitempty"`
+	}{
+		Repositories: c.InstallationRepositories,
+		Permissions:  c.InstallationPermissions,
+	})
+	if err != nil {
+		return err
+	}
+	// ghinstallation depends on its own version of go-github, so convert via JSON
+	return json.Unmarshal(b, &itr.InstallationTokenOptions)
+}
+
+// appCredentials returns GitHub App credentials. Values set in Config take precedence over environment variables.
+// If the credentials are not enough, it returns the app ID known with the error.
+func appCredentials(c *Config) (appID, installationID int64, privateKey []byte, err error) {
+	appID = c.AppID
+	if appID == 0 {
+		if envAppID := os.Getenv("GITHUB_APP_ID"); envAppID != "" {
+			appID, err = strconv.ParseInt(envAppID, 10, 64)
+			if err != nil {
+				return 0, 0, nil, err
+			}
+		}
+	}
+	privateKey = c.PrivateKey
+	if len(privateKey) == 0 {
+		if envPrivateKey := os.Getenv("GITHUB_APP_PRIVATE_KEY"); envPrivateKey != "" {
+			privateKey = []byte(repairKey(envPrivateKey))
+		}
+	}
+	switch {
+	case appID == 0 && len(privateKey) == 0 && c.AppSigner == nil:
+		return 0, 0, nil, errNoAppCredentials
+	case appID == 0:
+		return 0, 0, nil, errors.New("the GitHub App ID is not set")
+	case len(privateKey) == 0 && c.AppSigner == nil:
+		return appID, 0, nil, errors.New("the private key of the GitHub App is not set")
+	}
+	installationID = c.InstallationID
+	if installationID == 0 {
+		if envInstallationID := os.Getenv("GITHUB_APP_INSTALLATION_ID"); envInstallationID != "" {
+			installationID, err = strconv.ParseInt(envInstallationID, 10, 64)
+			if err != nil {
+				return 0, 0, nil, err
+			}
+		}
+	}
+	return appID, installationID, privateKey, nil
+}
+
 func detectInstallationID(c *Config, appID int64, privateKey []byte, ep string) (int64, error) {
 	owner, repo, err := detectOwnerRepo(c)
 	if err != nil {
 		return 0, err
 	}
-	tr := http.DefaultTransport
-	atr, err := ghinstallation.NewAppsTransport(tr, appID, privateKey)
+	atr, err := newAppsTransport(c, newTransport(c), appID, privateKey, ep)
 	if err != nil {
 		return 0, err
 	}
-	atr.BaseURL = ep
-	hc := &http.Client{Transport: atr}
-	gc := github.NewClient(hc)
-	baseEndpoint, err := url.Parse(ep)
+	gc := github.NewClient(&http.Client{Transport: atr})
+	gc.BaseURL, err = parseEndpoint(ep)
 	if err != nil {
 		return 0, err
 	}
-	if !strings.HasSuffix(baseEndpoint.Path, "/") {
-		baseEndpoint.Path += "/"
-	}
-	gc.BaseURL = baseEndpoint
-	ctx := context.Background()
+	return findInstallationID(context.Background(), gc, owner, repo)
+}
+
+// findInstallationID finds the installation ID for the owner (and repository) using the client authenticated as the GitHub App.
+func findInstallationID(ctx context.Context, gc *github.Client, owner, repo string) (int64, error) {
 	if repo != "" {
-		i, _, err := gc.Apps.FindRepositoryInstallation(ctx, owner, repo)
+		i, res, err := gc.Apps.FindRepositoryInstallation(ctx, owner, repo)
 		if err != nil {
+			if res != nil && res.StatusCode == http.StatusNotFound {
+				return 0, &InstallationNotFoundError{Owner: owner, Repo: repo, Err: err}
+			}
 			return 0, err
 		}
 		return i.GetID(), nil
 	}
 	page := 0
 	for {
-		is, res, err := gc.Apps.ListInstallations(context.Background(), &github.ListOptions{Page: page, PerPage: 1000})
+		is, res, err := gc.Apps.ListInstallations(ctx, &github.ListOptions{Page: page, PerPage: 1000})
 		if err != nil {
 			return 0, err
 		}
@@ -312,7 +725,26 @@ func detectInstallationID(c *Config, appID int64, privateKey []byte, ep string)
 		}
 		page = res.NextPage
 	}
-	return 0, fmt.Errorf("could not installation for %s", owner)
+	return 0, &InstallationNotFoundError{Owner: owner}
+}
+
+// newAppsTransport returns the transport authenticated as the GitHub App ( JWT ) sending requests using base.
+func newAppsTransport(c *Config, base http.RoundTripper, appID int64, privateKey []byte, ep string) (*ghinstallation.AppsTransport, error) {
+	tr := newRoundTripper(c, base, ep)
+	var (
+		atr *ghinstallation.AppsTransport
+		err error
+	)
+	if c.AppSigner != nil {
+		atr, err = ghinstallation.NewAppsTransportWithOptions(tr, appID, ghinstallation.WithSigner(&jwtSigner{signer: c.AppSigner}))
+	} else {
+		atr, err = ghinstallation.NewAppsTransport(tr, appID, privateKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	atr.BaseURL = ep
+	return atr, nil
 }
 
 func detectOwnerRepo(c *Config) (string, string, error) {
@@ -340,23 +772,46 @@ func detectOwnerRepo(c *Config) (string, string, error) {
 	if owner := os.Getenv("GITHUB_REPOSITORY_OWNER"); owner != "" {
 		return owner, "", nil
 	}
+	if c.GitRemote != "" {
+		if r, err := detectGitRemote(c.GitRemote); err == nil {
+			return r.owner, r.repo, nil
+		}
+	}
 	return "", "", errors.New("could not detect repository")
 }
 
-func httpClient(c *Config) *http.Client {
-	if c.HTTPClient != nil {
-		return c.HTTPClient
+// newTransport returns http.Transport with the timeouts, the proxy and the TLS settings of Config.
+// Without Proxy, it uses the proxy of env HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func newTransport(c *Config) *http.Transport {
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != nil {
+		proxy = http.ProxyURL(c.Proxy)
 	}
-	t := &http.Transport{
+	return &http.Transport{
+		Proxy: proxy,
 		Dial: (&net.Dialer{
 			Timeout: c.DialTimeout,
 		}).Dial,
 		TLSHandshakeTimeout: c.TLSHandshakeTimeout,
+		TLSClientConfig:     tlsConfig(c),
+	}
+}
+
+// newOAuthClient returns http.Client without authentication for OAuth and OIDC requests.
+func newOAuthClient(c *Config) *http.Client {
+	return &http.Client{
+		Timeout:   c.Timeout,
+		Transport: newTransport(c),
 	}
-	rt := roundTripper{
-		transport:   t,
-		accessToken: c.Token,
+}
+
+// httpClient returns http.Client that sends the token of Config to the hosts of the endpoints.
+func httpClient(c *Config, endpoints ...string) *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
 	}
+	rt := newRoundTripper(c, newTransport(c), endpoints...)
+	rt.accessToken = c.Token
 	return &http.Client{
 		Timeout:   c.Timeout,
 		Transport: rt,
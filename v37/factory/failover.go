@@ -0,0 +1,176 @@
+package factory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Failover enables to retry the request with the next credential in the resolution order when the response is 401 Unauthorized.
+// The credential that works is pinned and used for subsequent requests.
+func Failover() Option {
+	return func(c *Config) error {
+		c.Failover = true
+		return nil
+	}
+}
+
+// FailoverOnForbidden enables Failover, and also retries the request on 403 Forbidden "Resource not accessible by integration".
+func FailoverOnForbidden() Option {
+	return func(c *Config) error {
+		c.Failover = true
+		c.FailoverOnForbidden = true
+		return nil
+	}
+}
+
+type failoverCandidate struct {
+	once  sync.Once
+	rt    http.RoundTripper
+	err   error
+	newRT func() (http.RoundTripper, error)
+}
+
+func (fc *failoverCandidate) roundTripper() (http.RoundTripper, error) {
+	fc.once.Do(func() {
+		if fc.rt == nil {
+			fc.rt, fc.err = fc.newRT()
+		}
+	})
+	return fc.rt, fc.err
+}
+
+type failoverTransport struct {
+	candidates  []*failoverCandidate
+	onForbidden bool
+	pinned      atomic.Int64
+}
+
+var errNoFailoverCredential = errors.New("no credential to fail over to")
+
+// newFailoverTransport returns failoverTransport that tries the primary roundTripper first, and then the credentials in the resolution order
+// and the GitHub App. The credentials are resolved when the request fails over to them.
+func newFailoverTransport(c *Config, d *Detected, primary *roundTripper) *failoverTransport {
+	ft := &failoverTransport{onForbidden: c.FailoverOnForbidden}
+	ft.candidates = append(ft.candidates, &failoverCandidate{rt: primary})
+	host := d.Host
+	for _, s := range credentialSources(c) {
+		ft.candidates = append(ft.candidates, &failoverCandidate{
+			newRT: func() (http.RoundTripper, error) {
+				token, _, err := s.Token(context.Background(), host)
+				if err != nil {
+					return nil, err
+				}
+				if token == "" || token == primary.accessToken {
+					return nil, errNoFailoverCredential
+				}
+				rt := *primary
+				rt.accessToken = token
+				rt.refresher = nil
+				return &rt, nil
+			},
+		})
+	}
+	if _, _, _, err := appCredentials(c); err == nil && c.HTTPClient == nil {
+		ac := *c
+		ac.Token = ""
+		if ac.Owner == "" {
+			ac.Owner = d.Owner
+			ac.Repo = d.Repo
+		}
+		ep := d.RESTEndpoint
+		ft.candidates = append(ft.candidates, &failoverCandidate{
+			newRT: func() (http.RoundTripper, error) {
+				hc, err := newHTTPClientUsingGitHubApp(&ac, ep)
+				if err != nil {
+					return nil, err
+				}
+				if rt, ok := hc.Transport.(*roundTripper); ok {
+					rt.hosts = primary.hosts
+				}
+				return hc.Transport, nil
+			},
+		})
+	}
+	return ft
+}
+
+func (ft *failoverTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := int(ft.pinned.Load())
+	var res *http.Response
+	for i := start; i < len(ft.candidates); i++ {
+		rt, err := ft.candidates[i].roundTripper()
+		if err != nil {
+			// The credential is not available, so try the next one
+			continue
+		}
+		req := r
+		if res != nil {
+			req, err = replayRequest(r)
+			if err != nil {
+				// The request body can not be replayed, so return the response as is
+				return res, nil
+			}
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}
+		res, err = rt.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		if !ft.shouldFailover(res) {
+			if i != start {
+				ft.pinned.CompareAndSwap(int64(start), int64(i))
+			}
+			return res, nil
+		}
+	}
+	if res == nil {
+		if r.Body != nil {
+			_ = r.Body.Close()
+		}
+		return nil, errors.New("no credentials available")
+	}
+	return res, nil
+}
+
+func replayRequest(r *http.Request) (*http.Request, error) {
+	req := r.Clone(r.Context())
+	if r.Body == nil || r.Body == http.NoBody {
+		return req, nil
+	}
+	if r.GetBody == nil {
+		return nil, errors.New("the request body can not be replayed")
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = body
+	return req, nil
+}
+
+func (ft *failoverTransport) shouldFailover(res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return true
+	case http.StatusForbidden:
+		if !ft.onForbidden {
+			return false
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(b))
+		if err != nil {
+			return false
+		}
+		return strings.Contains(string(b), "Resource not accessible by integration")
+	default:
+		return false
+	}
+}
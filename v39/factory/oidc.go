@@ -0,0 +1,112 @@
+package factory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// errNotInGitHubActions is the error returned when the OIDC ID token can not be requested.
+var errNotInGitHubActions = errors.New("env ACTIONS_ID_TOKEN_REQUEST_URL and ACTIONS_ID_TOKEN_REQUEST_TOKEN are required to request OIDC ID token ( permissions: id-token: write )")
+
+// OIDCTokenExchange enables to exchange the GitHub Actions OIDC ID token for a GitHub token at the token broker.
+// The ID token is requested using env ACTIONS_ID_TOKEN_REQUEST_URL and ACTIONS_ID_TOKEN_REQUEST_TOKEN.
+// Without them ( outside GitHub Actions or without permissions id-token: write ), the next credential source is tried.
+// Failures of the request of the ID token and the exchange are returned as errors.
+// audience is the audience of the ID token ( optional ).
+//
+// The token broker receives POST brokerURL with the header "Authorization: Bearer <ID token>" and no body,
+// and must respond with a 2xx status and JSON {"token": "<GitHub token>"}.
+func OIDCTokenExchange(brokerURL, audience string) Option {
+	return func(c *Config) error {
+		if brokerURL == "" {
+			return nil
+		}
+		if _, err := url.Parse(brokerURL); err != nil {
+			return err
+		}
+		c.OIDCBrokerURL = brokerURL
+		c.OIDCAudience = audience
+		return nil
+	}
+}
+
+// exchangeOIDCToken requests the GitHub Actions OIDC ID token and exchanges it for a GitHub token at the token broker.
+func exchangeOIDCToken(ctx context.Context, c *Config) (string, error) {
+	hc := newOAuthClient(c)
+	idToken, err := requestOIDCIDToken(ctx, hc, c.OIDCAudience)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.OIDCBrokerURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", idToken))
+	req.Header.Set("Accept", "application/json")
+	res, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return "", fmt.Errorf("failed to exchange OIDC token at %s: %s", c.OIDCBrokerURL, res.Status)
+	}
+	var v struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return "", err
+	}
+	if v.Token == "" {
+		return "", fmt.Errorf("no token in the response from %s", c.OIDCBrokerURL)
+	}
+	return v.Token, nil
+}
+
+// requestOIDCIDToken requests the GitHub Actions OIDC ID token.
+// ref: https://docs.github.com/en/actions/deployment/security-hardening-your-deployments/about-security-hardening-with-openid-connect
+func requestOIDCIDToken(ctx context.Context, hc *http.Client, audience string) (string, error) {
+	reqURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	reqToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if reqURL == "" || reqToken == "" {
+		return "", errNotInGitHubActions
+	}
+	u, err := url.Parse(reqURL)
+	if err != nil {
+		return "", err
+	}
+	if audience != "" {
+		q := u.Query()
+		q.Set("audience", audience)
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", reqToken))
+	req.Header.Set("Accept", "application/json")
+	res, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return "", fmt.Errorf("failed to request OIDC ID token: %s", res.Status)
+	}
+	var v struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return "", err
+	}
+	if v.Value == "" {
+		return "", errors.New("no OIDC ID token in the response")
+	}
+	return v.Value, nil
+}
@@ -0,0 +1,61 @@
+package factory
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// AppSigner sets the signer of JWTs of the GitHub App ( RS256 ) such as the key held in KMS.
+// It takes precedence over the private key ( PrivateKey, PrivateKeyFile and env GITHUB_APP_PRIVATE_KEY ).
+func AppSigner(signer crypto.Signer) Option {
+	return func(c *Config) error {
+		if signer != nil {
+			c.AppSigner = signer
+		}
+		return nil
+	}
+}
+
+// NewFileSigner returns crypto.Signer using the RSA private key (PEM) read from path.
+// It is the reference implementation of the signer for AppSigner.
+func NewFileSigner(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// jwtSigner signs JWTs using crypto.Signer. It implements ghinstallation.Signer.
+type jwtSigner struct {
+	signer crypto.Signer
+}
+
+func (s *jwtSigner) Sign(claims jwt.Claims) (string, error) {
+	if _, ok := s.signer.Public().(*rsa.PublicKey); !ok {
+		return "", errors.New("the signer of the GitHub App must be RSA")
+	}
+	ss, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SigningString()
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(ss))
+	sig, err := s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{ss, base64.RawURLEncoding.EncodeToString(sig)}, "."), nil
+}
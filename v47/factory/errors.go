@@ -0,0 +1,69 @@
+package factory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoCredentials is the error returned when no credentials are found. The error wrapping it lists the sources tried.
+var ErrNoCredentials = errors.New("no credentials found")
+
+// errNoAppCredentials is the error returned when no GitHub App credentials are set.
+var errNoAppCredentials = errors.New("not enough credentials to authenticate using GitHub app")
+
+// AppAuthError is the error returned when the authentication using the GitHub App fails.
+type AppAuthError struct {
+	AppID          int64
+	InstallationID int64
+	// Tried is the sources of tokens tried before the GitHub App.
+	Tried []string
+	Err   error
+}
+
+func (e *AppAuthError) Error() string {
+	msg := fmt.Sprintf("failed to authenticate using GitHub App ( app ID: %d", e.AppID)
+	if e.InstallationID != 0 {
+		msg += fmt.Sprintf(", installation ID: %d", e.InstallationID)
+	}
+	msg += " )"
+	if len(e.Tried) > 0 {
+		msg += fmt.Sprintf(" after no token found ( tried: %s )", strings.Join(e.Tried, ", "))
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+func (e *AppAuthError) Unwrap() error {
+	return e.Err
+}
+
+// InstallationNotFoundError is the error returned when the installation of the GitHub App for the owner ( and repository ) is not found.
+type InstallationNotFoundError struct {
+	Owner string
+	Repo  string
+	// Err is the error of the API call. It is nil if the installation is not in the list of installations.
+	Err error
+}
+
+func (e *InstallationNotFoundError) Error() string {
+	target := e.Owner
+	if e.Repo != "" {
+		target = fmt.Sprintf("%s/%s", e.Owner, e.Repo)
+	}
+	if e.Err == nil {
+		return fmt.Sprintf("could not find the installation of the GitHub App for %s", target)
+	}
+	return fmt.Sprintf("could not find the installation of the GitHub App for %s: %v", target, e.Err)
+}
+
+func (e *InstallationNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// noCredentialsError returns the error wrapping ErrNoCredentials with the sources tried.
+func noCredentialsError(tried []string) error {
+	if len(tried) == 0 {
+		return ErrNoCredentials
+	}
+	return fmt.Errorf("%w ( tried: %s )", ErrNoCredentials, strings.Join(tried, ", "))
+}
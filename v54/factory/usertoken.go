@@ -0,0 +1,154 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const userTokenSource = "factory.RefreshableUserToken"
+
+// userTokenRefreshMargin is the margin to refresh the user access token before expiry.
+const userTokenRefreshMargin = time.Minute
+
+// UserAccessToken is the user access token of the GitHub App and its refresh token.
+type UserAccessToken struct {
+	AccessToken string
+	// ExpiresAt is the expiration time of the access token. If it is zero, the access token is refreshed only on 401.
+	ExpiresAt             time.Time
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+}
+
+// RefreshableUserToken sets the user access token of the GitHub App that is refreshed using the refresh token
+// before expiry or on 401 Unauthorized. clientID and clientSecret are the client ID and client secret of the GitHub App.
+// onRefresh is called with the new token pair so that callers can persist it.
+func RefreshableUserToken(t UserAccessToken, clientID, clientSecret string, onRefresh func(t UserAccessToken)) Option {
+	return func(c *Config) error {
+		if t.AccessToken == "" {
+			return nil
+		}
+		if clientID == "" || clientSecret == "" {
+			return errors.New("client ID and client secret are required to refresh the user access token")
+		}
+		c.UserAccessToken = &t
+		c.UserTokenClientID = clientID
+		c.UserTokenClientSecret = clientSecret
+		c.OnUserTokenRefresh = onRefresh
+		return nil
+	}
+}
+
+type userTokenRefresher struct {
+	mu           sync.Mutex
+	t            UserAccessToken
+	clientID     string
+	clientSecret string
+	ep           string
+	hc           *http.Client
+	onRefresh    func(t UserAccessToken)
+}
+
+func newUserTokenRefresher(c *Config, ep string) *userTokenRefresher {
+	return &userTokenRefresher{
+		t:            *c.UserAccessToken,
+		clientID:     c.UserTokenClientID,
+		clientSecret: c.UserTokenClientSecret,
+		ep:           ep,
+		hc:           newOAuthClient(c),
+		onRefresh:    c.OnUserTokenRefresh,
+	}
+}
+
+// token returns the access token. It refreshes the access token if it expires soon.
+func (u *userTokenRefresher) token(ctx context.Context) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.t.AccessToken != "" && (u.t.ExpiresAt.IsZero() || time.Until(u.t.ExpiresAt) > userTokenRefreshMargin) {
+		return u.t.AccessToken, nil
+	}
+	if err := u.refreshLocked(ctx); err != nil {
+		return "", err
+	}
+	return u.t.AccessToken, nil
+}
+
+// refresh refreshes the access token unless it has already been refreshed since stale was used.
+func (u *userTokenRefresher) refresh(ctx context.Context, stale string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.t.AccessToken != stale {
+		return u.t.AccessToken, nil
+	}
+	if err := u.refreshLocked(ctx); err != nil {
+		return "", err
+	}
+	return u.t.AccessToken, nil
+}
+
+// refreshLocked refreshes the access token using the refresh token.
+// ref: https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/refreshing-user-access-tokens
+func (u *userTokenRefresher) refreshLocked(ctx context.Context) error {
+	if u.t.RefreshToken == "" {
+		return errors.New("no refresh token")
+	}
+	if !u.t.RefreshTokenExpiresAt.IsZero() && time.Now().After(u.t.RefreshTokenExpiresAt) {
+		return errors.New("the refresh token has expired")
+	}
+	base, err := oauthBaseURL(u.ep)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	t := &oauthToken{}
+	if err := postForm(ctx, u.hc, base+"/login/oauth/access_token", url.Values{
+		"client_id":     {u.clientID},
+		"client_secret": {u.clientSecret},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {u.t.RefreshToken},
+	}, t); err != nil {
+		return err
+	}
+	if t.Error != "" {
+		return fmt.Errorf("failed to refresh the user access token: %s: %s", t.Error, t.ErrorDescription)
+	}
+	if t.AccessToken == "" {
+		return errors.New("no access token in the response")
+	}
+	u.t = UserAccessToken{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+	}
+	if t.ExpiresIn > 0 {
+		u.t.ExpiresAt = now.Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	if t.RefreshTokenExpiresIn > 0 {
+		u.t.RefreshTokenExpiresAt = now.Add(time.Duration(t.RefreshTokenExpiresIn) * time.Second)
+	}
+	if u.onRefresh != nil {
+		u.onRefresh(u.t)
+	}
+	return nil
+}
+
+// retryWithRefreshedToken refreshes the user access token and retries the request rejected with 401 Unauthorized.
+// If the token can not be refreshed or the request can not be replayed, it returns the response as is.
+func (rt *roundTripper) retryWithRefreshedToken(r *http.Request, res *http.Response, stale string) (*http.Response, error) {
+	req, err := replayRequest(r)
+	if err != nil {
+		return res, nil
+	}
+	token, err := rt.refresher.refresh(r.Context(), stale)
+	if err != nil {
+		return res, nil
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+	rt.setToken(req, token)
+	return rt.roundTrip(req)
+}
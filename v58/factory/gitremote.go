@@ -0,0 +1,158 @@
+package factory
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cli/go-gh/v2/pkg/auth"
+)
+
+// GitRemote enables to detect the owner/repo and the host from the URL of the git remote ( origin if name is empty )
+// of the working directory. They are used when they are not set by options and environment variables.
+// SSH config aliases ( e.g. git@github-work:OWNER/REPO.git ) and url.<base>.insteadOf of the git config are not resolved,
+// so the host of the remote is used only if it is github.com, SUBDOMAIN.ghe.com or a host that has a token.
+func GitRemote(name string) Option {
+	return func(c *Config) error {
+		if name == "" {
+			name = "origin"
+		}
+		c.GitRemote = name
+		return nil
+	}
+}
+
+type gitRemote struct {
+	host  string
+	owner string
+	repo  string
+}
+
+// detectGitRemote detects the host and owner/repo from the URL of the remote in the git config of the working directory.
+func detectGitRemote(name string) (*gitRemote, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	p, err := findGitConfig(wd)
+	if err != nil {
+		return nil, err
+	}
+	u, err := readRemoteURL(p, name)
+	if err != nil {
+		return nil, err
+	}
+	return parseRemoteURL(u)
+}
+
+// findGitConfig returns the path of the git config of the repository containing dir.
+func findGitConfig(dir string) (string, error) {
+	for {
+		p := filepath.Join(dir, ".git")
+		fi, err := os.Stat(p)
+		if err == nil {
+			if fi.IsDir() {
+				return filepath.Join(p, "config"), nil
+			}
+			// Worktrees and submodules have .git file ( gitdir: <path> )
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return "", err
+			}
+			gitdir, ok := strings.CutPrefix(strings.TrimSpace(string(b)), "gitdir:")
+			if !ok {
+				return "", fmt.Errorf("invalid .git file: %s", p)
+			}
+			gitdir = absPath(dir, strings.TrimSpace(gitdir))
+			if commondir, err := os.ReadFile(filepath.Join(gitdir, "commondir")); err == nil {
+				gitdir = absPath(gitdir, strings.TrimSpace(string(commondir)))
+			}
+			return filepath.Join(gitdir, "config"), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("not a git repository")
+		}
+		dir = parent
+	}
+}
+
+func absPath(base, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(base, p)
+}
+
+// readRemoteURL reads the URL of the remote from the git config.
+func readRemoteURL(p, name string) (string, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	section := fmt.Sprintf(`[remote "%s"]`, name)
+	in := false
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			in = line == section
+			continue
+		}
+		if !in {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if ok && strings.TrimSpace(k) == "url" {
+			return strings.Trim(strings.TrimSpace(v), `"`), nil
+		}
+	}
+	return "", fmt.Errorf("could not find the URL of the remote %s in %s", name, p)
+}
+
+// isKnownHost reports whether the host is github.com, SUBDOMAIN.ghe.com or a host that has a token
+// ( environment variables, the gh config and the gh command ).
+func isKnownHost(host string) bool {
+	if host == defaultHost || isTenancyHost(host) {
+		return true
+	}
+	token, _ := auth.TokenForHost(host)
+	return token != ""
+}
+
+// parseRemoteURL parses the URL of the remote ( https://HOST/OWNER/REPO.git, ssh://git@HOST/OWNER/REPO.git, git@HOST:OWNER/REPO.git ).
+func parseRemoteURL(in string) (*gitRemote, error) {
+	var host, p string
+	if strings.Contains(in, "://") {
+		u, err := url.Parse(in)
+		if err != nil {
+			return nil, err
+		}
+		host, p = u.Hostname(), u.Path
+	} else {
+		// scp-like syntax
+		hostpart, path, ok := strings.Cut(in, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid remote URL: %s", in)
+		}
+		if _, h, ok := strings.Cut(hostpart, "@"); ok {
+			hostpart = h
+		}
+		host, p = hostpart, path
+	}
+	host = strings.ToLower(host)
+	if host == "ssh."+defaultHost {
+		host = defaultHost
+	}
+	splitted := strings.Split(strings.TrimSuffix(strings.Trim(p, "/"), ".git"), "/")
+	if host == "" || len(splitted) < 2 || splitted[len(splitted)-2] == "" || splitted[len(splitted)-1] == "" {
+		return nil, fmt.Errorf("invalid remote URL: %s", in)
+	}
+	return &gitRemote{
+		host:  host,
+		owner: splitted[len(splitted)-2],
+		repo:  splitted[len(splitted)-1],
+	}, nil
+}
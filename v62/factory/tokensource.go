@@ -0,0 +1,158 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/cli/go-gh/v2/pkg/auth"
+)
+
+// TokenSource is a source of tokens for authentication.
+type TokenSource interface {
+	// Token returns a token for the host and the name of the source of the token.
+	// If the source has no token, Token returns an empty token and a nil error, and then the next source is tried.
+	Token(ctx context.Context, host string) (token, source string, err error)
+}
+
+// TokenSourceFunc is an adapter to allow the use of ordinary functions as TokenSource.
+type TokenSourceFunc func(ctx context.Context, host string) (token, source string, err error)
+
+// Token calls f(ctx, host).
+func (f TokenSourceFunc) Token(ctx context.Context, host string) (string, string, error) {
+	return f(ctx, host)
+}
+
+// CredentialChain sets the sources of tokens. The sources are tried in the order given,
+// and the first token found is used instead of the token detected by environment variables and the gh config.
+func CredentialChain(sources ...TokenSource) Option {
+	return func(c *Config) error {
+		for _, s := range sources {
+			if s == nil {
+				return errors.New("nil token source")
+			}
+		}
+		c.TokenSources = append(c.TokenSources, sources...)
+		return nil
+	}
+}
+
+// StaticToken returns a TokenSource that returns the token.
+func StaticToken(token string) TokenSource {
+	return TokenSourceFunc(func(_ context.Context, _ string) (string, string, error) {
+		return token, "static", nil
+	})
+}
+
+// GHToken returns a TokenSource that returns the token detected by environment variables, the gh config and the gh command.
+func GHToken() TokenSource {
+	return TokenSourceFunc(func(_ context.Context, host string) (string, string, error) {
+		token, source := auth.TokenForHost(host)
+		if token == "" {
+			source = "environment variables, gh config and gh command"
+		}
+		return token, source, nil
+	})
+}
+
+// TokenFile returns a TokenSource that returns the token read from the file. If the file does not exist, it returns an empty token.
+func TokenFile(path string) TokenSource {
+	return TokenSourceFunc(func(_ context.Context, _ string) (string, string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return "", path, nil
+			}
+			return "", path, err
+		}
+		return strings.TrimSpace(string(b)), path, nil
+	})
+}
+
+// TokenCommand returns a TokenSource that returns the token printed to stdout by the command.
+func TokenCommand(name string, args ...string) TokenSource {
+	return TokenSourceFunc(func(ctx context.Context, _ string) (string, string, error) {
+		out, err := exec.CommandContext(ctx, name, args...).Output() //nolint:gosec
+		if err != nil {
+			return "", name, fmt.Errorf("failed to get token using %s: %w", name, err)
+		}
+		return strings.TrimSpace(string(out)), name, nil
+	})
+}
+
+// ResolveToken returns the token and the name of the source of the token resolved in the same order as NewGithubClient.
+// If no token is found, it returns an empty token ( GitHub App credentials are not resolved ).
+func ResolveToken(opts ...Option) (token, tokenSource string, err error) {
+	c, err := newConfigWithOptions(opts...)
+	if err != nil {
+		return "", "", err
+	}
+	d, err := detectHostAndEndpoints(c)
+	if err != nil {
+		return "", "", err
+	}
+	return resolveToken(context.Background(), c, d.Host)
+}
+
+// resolveToken resolves the token in the order of Config.Token, the user access token, OIDC token exchange and the token sources.
+func resolveToken(ctx context.Context, c *Config, host string) (string, string, error) {
+	token, source, _, err := resolveCredentials(ctx, c, host)
+	return token, source, err
+}
+
+// resolveCredentials returns the first token found in the sources of credentialSources.
+// It also returns the names of the sources tried without a token.
+func resolveCredentials(ctx context.Context, c *Config, host string) (token, source string, tried []string, err error) {
+	for _, s := range credentialSources(c) {
+		token, source, err := s.Token(ctx, host)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if token != "" {
+			return token, source, tried, nil
+		}
+		tried = append(tried, source)
+	}
+	return "", "", tried, nil
+}
+
+// credentialSources returns the sources of tokens in the order of Config.Token, the user access token, OIDC token exchange and the token sources.
+// The sources are not resolved until Token is called.
+func credentialSources(c *Config) []TokenSource {
+	if c.SkipAuth {
+		return nil
+	}
+	var sources []TokenSource
+	if c.Token != "" {
+		token := c.Token
+		sources = append(sources, TokenSourceFunc(func(_ context.Context, _ string) (string, string, error) {
+			return token, "factory.Token", nil
+		}))
+	}
+	if c.UserAccessToken != nil && c.UserAccessToken.AccessToken != "" {
+		token := c.UserAccessToken.AccessToken
+		sources = append(sources, TokenSourceFunc(func(_ context.Context, _ string) (string, string, error) {
+			return token, userTokenSource, nil
+		}))
+	}
+	if c.OIDCBrokerURL != "" {
+		sources = append(sources, TokenSourceFunc(func(ctx context.Context, _ string) (string, string, error) {
+			token, err := exchangeOIDCToken(ctx, c)
+			if errors.Is(err, errNotInGitHubActions) {
+				return "", "factory.OIDCTokenExchange", nil
+			}
+			return token, "factory.OIDCTokenExchange", err
+		}))
+	}
+	if c.AppID != 0 {
+		// Explicit GitHub App credentials take precedence over the detected token
+		return sources
+	}
+	if len(c.TokenSources) == 0 {
+		return append(sources, GHToken())
+	}
+	return append(sources, c.TokenSources...)
+}
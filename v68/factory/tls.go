@@ -0,0 +1,74 @@
+package factory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// RootCAs adds the CA certificates (PEM) to trust in addition to the system certificates, such as the internal CA of GitHub Enterprise Server.
+// It takes precedence over env GITHUB_CA_CERT_FILE.
+func RootCAs(pem []byte) Option {
+	return func(c *Config) error {
+		if len(pem) == 0 {
+			return nil
+		}
+		if c.RootCAs == nil {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			c.RootCAs = pool
+		}
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return errors.New("no CA certificates found in PEM")
+		}
+		return nil
+	}
+}
+
+// CACertFile adds the CA certificates (PEM) read from path as RootCAs does. It takes precedence over env GITHUB_CA_CERT_FILE.
+func CACertFile(path string) Option {
+	return func(c *Config) error {
+		if path == "" {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := RootCAs(b)(c); err != nil {
+			return fmt.Errorf("failed to load the CA certificates %s: %w", path, err)
+		}
+		return nil
+	}
+}
+
+// ClientCertificate sets the client certificate and its private key (PEM) for mutual TLS.
+func ClientCertificate(cert, key []byte) Option {
+	return func(c *Config) error {
+		if len(cert) == 0 && len(key) == 0 {
+			return nil
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return err
+		}
+		c.ClientCertificates = append(c.ClientCertificates, pair)
+		return nil
+	}
+}
+
+// tlsConfig returns tls.Config of Config. It returns nil to use the default.
+func tlsConfig(c *Config) *tls.Config {
+	if c.RootCAs == nil && len(c.ClientCertificates) == 0 {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		RootCAs:      c.RootCAs,
+		Certificates: c.ClientCertificates,
+	}
+}
@@ -0,0 +1,247 @@
+package factory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const deviceFlowGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceFlowDefaultInterval is the polling interval used when the response has no interval ( RFC 8628 ).
+const deviceFlowDefaultInterval = 5 * time.Second
+
+// DeviceFlow enables to login using OAuth device flow when no credentials are found.
+// clientID is the client ID of the OAuth App or GitHub App.
+func DeviceFlow(clientID string, scopes ...string) Option {
+	return func(c *Config) error {
+		if clientID == "" {
+			return nil
+		}
+		c.DeviceFlowClientID = clientID
+		c.DeviceFlowScopes = scopes
+		return nil
+	}
+}
+
+// DeviceFlowTokenFile sets the path of the file to persist the token obtained by device flow for reuse.
+// The token is reused only for the same host. If the token is rejected ( 401 Unauthorized ), the file is removed and device flow runs again.
+func DeviceFlowTokenFile(path string) Option {
+	return func(c *Config) error {
+		if path != "" {
+			c.DeviceFlowTokenFile = path
+		}
+		return nil
+	}
+}
+
+// DeviceFlowOutput sets the writer to print the one-time code of device flow to. The default is os.Stderr.
+func DeviceFlowOutput(w io.Writer) Option {
+	return func(c *Config) error {
+		if w != nil {
+			c.DeviceFlowOutput = w
+		}
+		return nil
+	}
+}
+
+// deviceFlowSavedToken is the content of DeviceFlowTokenFile.
+type deviceFlowSavedToken struct {
+	Host        string `json:"host"`
+	AccessToken string `json:"access_token"`
+}
+
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type oauthToken struct {
+	AccessToken           string `json:"access_token"`
+	TokenType             string `json:"token_type"`
+	Scope                 string `json:"scope"`
+	ExpiresIn             int    `json:"expires_in"`
+	RefreshToken          string `json:"refresh_token"`
+	RefreshTokenExpiresIn int    `json:"refresh_token_expires_in"`
+	Error                 string `json:"error"`
+	ErrorDescription      string `json:"error_description"`
+	Interval              int    `json:"interval"`
+}
+
+// deviceFlowToken returns the token obtained by OAuth device flow against the host of the endpoint.
+// ref: https://docs.github.com/en/apps/oauth-apps/building-oauth-apps/authorizing-oauth-apps#device-flow
+func deviceFlowToken(ctx context.Context, c *Config, ep string) (string, error) {
+	base, err := oauthBaseURL(ep)
+	if err != nil {
+		return "", err
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(base, "https://"), "http://")
+	if c.DeviceFlowTokenFile != "" {
+		token, err := loadDeviceFlowToken(ctx, c, ep, host)
+		if err != nil {
+			return "", err
+		}
+		if token != "" {
+			return token, nil
+		}
+	}
+	hc := newOAuthClient(c)
+	dc := &deviceCode{}
+	if err := postForm(ctx, hc, base+"/login/device/code", url.Values{
+		"client_id": {c.DeviceFlowClientID},
+		"scope":     {strings.Join(c.DeviceFlowScopes, " ")},
+	}, dc); err != nil {
+		return "", err
+	}
+	if dc.DeviceCode == "" {
+		return "", errors.New("failed to request device code")
+	}
+	w := c.DeviceFlowOutput
+	if w == nil {
+		w = os.Stderr
+	}
+	_, _ = fmt.Fprintf(w, "! First copy your one-time code: %s\nOpen %s in your browser and enter the code\n", dc.UserCode, dc.VerificationURI)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = c.DeviceFlowInterval
+	}
+	if interval <= 0 {
+		interval = deviceFlowDefaultInterval
+	}
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+		if dc.ExpiresIn > 0 && time.Now().After(deadline) {
+			return "", errors.New("device code expired")
+		}
+		t := &oauthToken{}
+		if err := postForm(ctx, hc, base+"/login/oauth/access_token", url.Values{
+			"client_id":   {c.DeviceFlowClientID},
+			"device_code": {dc.DeviceCode},
+			"grant_type":  {deviceFlowGrantType},
+		}, t); err != nil {
+			return "", err
+		}
+		switch t.Error {
+		case "":
+			if t.AccessToken == "" {
+				return "", errors.New("no access token in the response")
+			}
+			if c.DeviceFlowTokenFile != "" {
+				if err := saveDeviceFlowToken(c.DeviceFlowTokenFile, host, t.AccessToken); err != nil {
+					return "", err
+				}
+			}
+			return t.AccessToken, nil
+		case "authorization_pending":
+		case "slow_down":
+			if t.Interval > 0 {
+				interval = time.Duration(t.Interval) * time.Second
+			} else {
+				interval += 5 * time.Second
+			}
+		default:
+			return "", fmt.Errorf("failed to login using device flow: %s: %s", t.Error, t.ErrorDescription)
+		}
+	}
+}
+
+// loadDeviceFlowToken returns the token saved for the host. It returns an empty token if no token is saved for the host.
+// The token rejected by the API is removed.
+func loadDeviceFlowToken(ctx context.Context, c *Config, ep, host string) (string, error) {
+	b, err := os.ReadFile(c.DeviceFlowTokenFile)
+	if err != nil {
+		return "", nil
+	}
+	saved := &deviceFlowSavedToken{}
+	if err := json.Unmarshal(b, saved); err != nil || saved.Host != host || saved.AccessToken == "" {
+		return "", nil
+	}
+	rt := newRoundTripper(c, newTransport(c), ep)
+	rt.accessToken = saved.AccessToken
+	hc := &http.Client{
+		Timeout:   c.Timeout,
+		Transport: rt,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep+"/user", nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		// The token can not be validated, so use it as it is
+		return saved.AccessToken, nil
+	}
+	_ = res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		if err := os.Remove(c.DeviceFlowTokenFile); err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+	return saved.AccessToken, nil
+}
+
+func saveDeviceFlowToken(path, host, token string) error {
+	b, err := json.Marshal(&deviceFlowSavedToken{Host: host, AccessToken: token})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+// oauthBaseURL returns the base URL of OAuth endpoints ( /login/* ) for the API endpoint.
+func oauthBaseURL(ep string) (string, error) {
+	u, err := url.Parse(ep)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid endpoint: %s", ep)
+	}
+	host := u.Host
+	switch {
+	case host == strings.TrimPrefix(defaultV3Endpoint, "https://"):
+		host = defaultHost
+	case strings.HasPrefix(host, "api.") && isTenancyHost(strings.TrimPrefix(host, "api.")):
+		host = strings.TrimPrefix(host, "api.")
+	}
+	return fmt.Sprintf("%s://%s", u.Scheme, host), nil
+}
+
+func postForm(ctx context.Context, hc *http.Client, u string, values url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to request %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}